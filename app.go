@@ -1,10 +1,16 @@
 package main
 
 import (
+	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/atotto/clipboard"
+	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 )
 
 // statusMsg represents a status message update
@@ -19,30 +25,94 @@ func (m Model) showStatus(message string) tea.Cmd {
 	}
 }
 
+// pickedMsg ends the program with a chosen command
+type pickedMsg struct {
+	command string
+}
+
+// AppOptions holds the settings that shape how the app behaves
+type AppOptions struct {
+	// Follow keeps watching the history file for new commands
+	Follow bool
+	// Debounce delays searches until typing pauses
+	Debounce time.Duration
+	// Rank is the order the history starts out in
+	Rank RankMode
+	// Group lists identical commands once even in recency order
+	Group bool
+	// NormalizeWhitespace groups commands that only differ in spacing
+	NormalizeWhitespace bool
+	// Keys binds the actions to keys
+	Keys KeyMap
+	// Pick ends the program with the chosen command instead of copying it
+	Pick bool
+	// Height, if set, draws the interface inline in at most this many lines
+	// instead of filling the window
+	Height int
+}
+
 type Model struct {
 	logger        *LoggerService
 	historyUI     *FishHistoryUI
 	searchService *SearchService
+	discovery     *HistoryDiscoveryService
 	// Search state
 	searchMode bool
+	// Session picker state
+	sessionMode  bool
+	sessions     []HistorySession
+	sessionIndex int
 	// History selection state
 	historySelectedIndex int
+	// Load error state
+	pathInput   textinput.Model
+	editingPath bool
+	// follow keeps watching the history file for new commands
+	follow bool
+	// keys binds the actions to keys and showHelp shows every binding
+	keys     KeyMap
+	showHelp bool
+	// Vim state: the count typed so far and whether g was pressed once
+	count    int
+	pendingG bool
+	// pick ends the program with the chosen command, kept in picked
+	pick   bool
+	picked string
+	// inlineHeight is the most lines an inline interface takes, 0 when it
+	// fills the window, and quitting clears it on the way out
+	inlineHeight int
+	quitting     bool
+	// The row last clicked and when, to tell a double click
+	lastClickRow int
+	lastClickAt  time.Time
 	// Status message for UI feedback
 	statusMessage string
 	statusTimer   int
+	// view is the view rendered by the last update, which View returns
+	view string
 }
 
 func (m Model) Init() tea.Cmd {
 	m.logger.Info("Model initialized")
-	return m.loadFishHistory
+	return m.historyUI.StartLoading()
 }
 
-func (m Model) loadFishHistory() tea.Msg {
-	return m.historyUI.LoadHistoryMessage()
+// Update handles a message, then renders and lays out the view it leads to
+func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	model, cmd := m.update(msg)
+	if m, ok := model.(Model); ok {
+		m.view = m.layout()
+		return m, cmd
+	}
+	return model, cmd
 }
 
-func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+func (m Model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
+	case pickedMsg:
+		m.logger.Info("Command picked")
+		m.picked = msg.command
+		return m.quit()
 	case statusMsg:
 		m.statusMessage = msg.message
 		m.statusTimer = 3 // Show for 3 seconds
@@ -62,148 +132,725 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 		}
 		return m, nil
+	case historyBatchMsg:
+		if !m.historyUI.service.ApplyBatch(msg) {
+			return m, nil
+		}
+		if m.hasSearch() {
+			return m, tea.Batch(msg.loader.Next, m.searchService.Refresh(m.historyUI.service.GetRanked()))
+		}
+		return m, msg.loader.Next
 	case fishHistoryMsg:
+		if !m.historyUI.service.FinishLoad(msg) {
+			return m, nil
+		}
 		if msg.err != nil {
-			m.logger.Errorf("Failed to load fish history: %v", msg.err)
+			m.logger.Errorf("Failed to load history: %v", msg.err)
+			m.searchMode = false
+			m.searchService.Clear()
+			m.historyUI.ClearSearchInput()
 			return m, nil
 		}
-		m.logger.Infof("Fish history loaded successfully")
+		m.logger.Infof("History loaded successfully")
+		var cmds []tea.Cmd
+		if m.hasSearch() {
+			cmds = append(cmds, m.searchService.Refresh(m.historyUI.service.GetRanked()))
+		}
+		if watcher := m.historyUI.service.GetWatcher(); m.follow && watcher != nil {
+			cmds = append(cmds, watcher.Tick())
+		}
+		if len(msg.diagnostics) > 0 {
+			cmds = append(cmds, m.showStatus(fmt.Sprintf("⚠️ %d history entries could not be parsed, see debug.log", len(msg.diagnostics))))
+		}
+		return m, tea.Batch(cmds...)
+	case historyWatchMsg:
+		if msg.watcher != m.historyUI.service.GetWatcher() {
+			return m, nil
+		}
+		if msg.err != nil {
+			m.logger.Warnf("Failed to check history for new commands: %v", msg.err)
+			return m, msg.watcher.Tick()
+		}
+
+		history := m.historyUI.service.GetRanked()
+		var selected *FishCommand
+		if m.historySelectedIndex < len(history) {
+			selected = &history[m.historySelectedIndex]
+		}
+		if m.historyUI.service.ApplyWatch(msg) {
+			history = m.historyUI.service.GetRanked()
+			if selected != nil {
+				if i := findCommand(slices.All(history), *selected); i >= 0 {
+					m.historySelectedIndex = i
+				}
+			}
+			m.historySelectedIndex = min(m.historySelectedIndex, max(len(history)-1, 0))
+			if m.hasSearch() {
+				return m, tea.Batch(msg.watcher.Tick(), m.searchService.Refresh(history))
+			}
+		}
+		return m, msg.watcher.Tick()
+	case searchResultsMsg:
+		m.searchService.ApplyResults(msg)
+		return m, nil
+	case searchPendingMsg:
+		// Nothing to update, the view shows that the search is still running
 		return m, nil
 	case tea.WindowSizeMsg:
 		// Handle window resizing
-		m.historyUI.SetSize(msg.Width, msg.Height)
+		height := msg.Height
+		if m.inlineHeight > 0 {
+			height = min(height, m.inlineHeight)
+		}
+		m.historyUI.SetSize(msg.Width, height)
 		return m, nil
+	case tea.MouseMsg:
+		return m.updateMouse(msg)
 	case tea.KeyMsg:
-		key := msg.String()
-		m.logger.Debugf("Key pressed: %s", key)
+		m.logger.Debugf("Key pressed: %s", msg.String())
 
+		if m.showHelp {
+			// Any key closes the help
+			m.showHelp = false
+			return m, nil
+		}
+		if m.sessionMode {
+			return m.updateSessionPicker(msg)
+		}
+		if key.Matches(msg, m.keys.Sessions) && !m.editingPath {
+			return m.openSessionPicker()
+		}
+		if m.historyUI.service.GetLoadError() != nil {
+			return m.updateErrorScreen(msg)
+		}
 		if m.searchMode {
-			// Handle search mode
-			switch key {
-			case "esc":
-				m.logger.Info("Exiting search mode")
-				m.searchMode = false
-				m.searchService.Clear()
-				return m, nil
-			case "ctrl+c":
-				m.logger.Info("Quit command received")
-				return m, tea.Quit
-			case "up", "ctrl+k":
-				m.searchService.NavigateUp()
-				return m, nil
-			case "down", "ctrl+j":
-				m.searchService.NavigateDown()
-				return m, nil
-			case "backspace":
-				if len(m.searchService.GetQuery()) > 0 {
-					newQuery := m.searchService.GetQuery()[:len(m.searchService.GetQuery())-1]
-					m.searchService.UpdateQuery(m.historyUI.service.GetHistory(), newQuery)
-				}
-				return m, nil
-			case "enter":
-				// Copy selected command to clipboard
-				selectedCmd := m.searchService.GetSelectedCommand()
-				if selectedCmd != nil {
-					err := clipboard.WriteAll(selectedCmd.Command)
-					if err != nil {
-						m.logger.Errorf("Failed to copy to clipboard: %v", err)
-						return m, m.showStatus("❌ Copy failed")
-					} else {
-						m.logger.Infof("Copied command to clipboard: %s", selectedCmd.Command)
-						return m, m.showStatus("✅ Copied to clipboard")
-					}
-				}
-				return m, nil
-			default:
-				// Add character to search query (including j, k, q)
-				if len(key) == 1 {
-					newQuery := m.searchService.GetQuery() + key
-					m.searchService.UpdateQuery(m.historyUI.service.GetHistory(), newQuery)
-				}
+			return m.updateSearchMode(msg)
+		}
+		return m.updateHistoryMode(msg)
+	default:
+		// Cursor blinks and clipboard pastes of the search input
+		if m.searchMode {
+			return m.updateSearchInput(msg)
+		}
+	}
+	return m, nil
+}
+
+// updateSearchMode handles keys while searching. Printable keys always edit
+// the query, even when bound to an action.
+func (m Model) updateSearchMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.Type == tea.KeyRunes && !msg.Alt {
+		return m.updateSearchInput(msg)
+	}
+
+	switch {
+	case key.Matches(msg, m.keys.Back):
+		m.logger.Info("Exiting search mode")
+		m.searchMode = false
+		m.searchService.Clear()
+		m.historyUI.ClearSearchInput()
+		return m, nil
+	case key.Matches(msg, m.keys.Quit):
+		m.logger.Info("Quit command received")
+		return m.quit()
+	case key.Matches(msg, m.keys.Up):
+		m.searchService.NavigateUp()
+		return m, nil
+	case key.Matches(msg, m.keys.Down):
+		m.searchService.NavigateDown()
+		return m, nil
+	case key.Matches(msg, m.keys.PageUp):
+		m.searchService.MoveSelection(-m.historyUI.PageSize())
+		return m, nil
+	case key.Matches(msg, m.keys.PageDown):
+		m.searchService.MoveSelection(m.historyUI.PageSize())
+		return m, nil
+	case key.Matches(msg, m.keys.Home):
+		m.searchService.SelectFirst()
+		return m, nil
+	case key.Matches(msg, m.keys.End):
+		m.searchService.SelectLast()
+		return m, nil
+	case key.Matches(msg, m.keys.MatchMode):
+		return m, m.searchService.CycleMatchMode(m.historyUI.service.GetRanked())
+	case key.Matches(msg, m.keys.RankMode):
+		return m.cycleRankMode()
+	case key.Matches(msg, m.keys.Group):
+		return m.toggleGrouping()
+	case key.Matches(msg, m.keys.Preview):
+		m.historyUI.TogglePreview()
+		return m, nil
+	case key.Matches(msg, m.keys.Help):
+		m.showHelp = true
+		return m, nil
+	case key.Matches(msg, m.keys.Expand):
+		if selected := m.searchService.GetSelectedCommand(); selected != nil {
+			m.historyUI.ToggleExpanded(*selected)
+		}
+		return m, nil
+	case m.keys.Modal && key.Matches(msg, m.keys.Select):
+		return m.confirmSearch(), nil
+	case key.Matches(msg, m.keys.Copy):
+		return m, m.copyCommand(m.searchService.GetSelectedCommand())
+	}
+	// Everything else edits the query
+	return m.updateSearchInput(msg)
+}
+
+// updateHistoryMode handles keys while browsing the history. With a modal
+// keymap, a count typed before a movement repeats it.
+func (m Model) updateHistoryMode(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	history := m.historyUI.service.GetRanked()
+	var selected *FishCommand
+	if m.historySelectedIndex < len(history) {
+		selected = &history[m.historySelectedIndex]
+	}
+
+	// Any key but a digit or a first g ends the pending count
+	typedCount, pendingG := m.count, m.pendingG
+	count := max(typedCount, 1)
+	m.count, m.pendingG = 0, false
+
+	if m.keys.Modal {
+		switch {
+		case isCountDigit(msg, typedCount):
+			m.count = min(typedCount*10+int(msg.Runes[0]-'0'), maxCount)
+			return m, nil
+		case key.Matches(msg, m.keys.Top):
+			if !pendingG {
+				m.count, m.pendingG = typedCount, true
 				return m, nil
 			}
-		} else {
-			// Handle normal mode
-			switch key {
-			case "esc", "ctrl+c":
-				m.logger.Info("Quit command received")
-				return m, tea.Quit
-			case "/":
-				m.logger.Info("Entering search mode")
-				m.searchMode = true
-				// Initialize with empty query to show last 5 commands
-				m.searchService.UpdateQuery(m.historyUI.service.GetHistory(), "")
-				return m, nil
-			case "up", "ctrl+k":
-				if m.historySelectedIndex > 0 {
-					m.historySelectedIndex--
-					m.logger.Debugf("History navigation up: index=%d", m.historySelectedIndex)
-				}
-				return m, nil
-			case "down", "ctrl+j":
-				// Limit to top 5 commands (0-4)
-				maxIndex := 4
-				if m.historySelectedIndex < maxIndex {
-					m.historySelectedIndex++
-					m.logger.Debugf("History navigation down: index=%d", m.historySelectedIndex)
-				}
-				return m, nil
-			case "enter":
-				// Copy selected command to clipboard
-				history := m.historyUI.service.GetHistory()
-				if m.historySelectedIndex >= 0 && m.historySelectedIndex < len(history) {
-					selectedCmd := history[m.historySelectedIndex]
-					err := clipboard.WriteAll(selectedCmd.Command)
-					if err != nil {
-						m.logger.Errorf("Failed to copy to clipboard: %v", err)
-						return m, m.showStatus("❌ Copy failed")
-					} else {
-						m.logger.Infof("Copied command to clipboard: %s", selectedCmd.Command)
-						return m, m.showStatus("✅ Copied to clipboard")
-					}
-				}
+			return m.moveHistorySelection(count - 1 - m.historySelectedIndex), nil
+		case key.Matches(msg, m.keys.Bottom):
+			if typedCount > 0 {
+				return m.moveHistorySelection(typedCount - 1 - m.historySelectedIndex), nil
+			}
+			return m.moveHistorySelection(len(history)), nil
+		case key.Matches(msg, m.keys.HalfPageDown):
+			return m.moveHistorySelection(count * max(m.historyUI.PageSize()/2, 1)), nil
+		case key.Matches(msg, m.keys.HalfPageUp):
+			return m.moveHistorySelection(-count * max(m.historyUI.PageSize()/2, 1)), nil
+		case key.Matches(msg, m.keys.NextMatch):
+			return m.jumpToMatch(count)
+		case key.Matches(msg, m.keys.PrevMatch):
+			return m.jumpToMatch(-count)
+		case key.Matches(msg, m.keys.Yank):
+			return m, m.copyCommand(selected)
+		case key.Matches(msg, m.keys.Back):
+			m.searchService.Clear()
+			return m, nil
+		}
+	}
+
+	switch {
+	case key.Matches(msg, m.keys.Back), key.Matches(msg, m.keys.Quit):
+		m.logger.Info("Quit command received")
+		return m.quit()
+	case key.Matches(msg, m.keys.RankMode):
+		return m.cycleRankMode()
+	case key.Matches(msg, m.keys.Group):
+		return m.toggleGrouping()
+	case key.Matches(msg, m.keys.Preview):
+		m.historyUI.TogglePreview()
+		return m, nil
+	case key.Matches(msg, m.keys.Help):
+		m.showHelp = true
+		return m, nil
+	case key.Matches(msg, m.keys.Expand):
+		if selected != nil {
+			m.historyUI.ToggleExpanded(*selected)
+		}
+		return m, nil
+	case key.Matches(msg, m.keys.Search):
+		m.logger.Info("Entering search mode")
+		m.searchMode = true
+		// Initialize with empty query to show every command
+		return m, m.searchService.UpdateQuery(history, "")
+	case key.Matches(msg, m.keys.Up):
+		return m.moveHistorySelection(-count), nil
+	case key.Matches(msg, m.keys.Down):
+		return m.moveHistorySelection(count), nil
+	case key.Matches(msg, m.keys.PageUp):
+		return m.moveHistorySelection(-m.historyUI.PageSize()), nil
+	case key.Matches(msg, m.keys.PageDown):
+		return m.moveHistorySelection(m.historyUI.PageSize()), nil
+	case key.Matches(msg, m.keys.Home):
+		return m.moveHistorySelection(-len(history)), nil
+	case key.Matches(msg, m.keys.End):
+		return m.moveHistorySelection(len(history)), nil
+	case key.Matches(msg, m.keys.Copy):
+		return m, m.copyCommand(selected)
+	}
+
+	// Auto-enter search mode when typing or pasting
+	if msg.Type == tea.KeyRunes && !msg.Alt && !m.keys.Modal {
+		m.logger.Info("Auto-entering search mode")
+		m.searchMode = true
+		// Start searching for the typed text
+		return m.updateSearchInput(msg)
+	}
+	return m, nil
+}
+
+// quit ends the program
+func (m Model) quit() (tea.Model, tea.Cmd) {
+	m.quitting = true
+	return m, tea.Quit
+}
+
+// Mouse settings
+const (
+	// wheelRows is how many rows a turn of the mouse wheel moves
+	wheelRows = 3
+	// doubleClickTime is the longest time between the clicks of a double
+	// click
+	doubleClickTime = 400 * time.Millisecond
+)
+
+// updateMouse handles the mouse in the history and search views: the wheel
+// moves the selection, a click selects a row and a double click copies it
+func (m Model) updateMouse(msg tea.MouseMsg) (tea.Model, tea.Cmd) {
+	if m.showHelp || m.sessionMode || m.historyUI.service.GetLoadError() != nil {
+		return m, nil
+	}
+
+	delta := 0
+	switch {
+	case msg.Button == tea.MouseButtonWheelUp:
+		delta = -wheelRows
+	case msg.Button == tea.MouseButtonWheelDown:
+		delta = wheelRows
+	case msg.Button == tea.MouseButtonLeft && msg.Action == tea.MouseActionPress:
+		row, ok := m.historyUI.RowAt(msg.X, msg.Y)
+		if !ok {
+			return m, nil
+		}
+		doubleClick := row == m.lastClickRow && time.Since(m.lastClickAt) < doubleClickTime
+		m.lastClickRow, m.lastClickAt = row, time.Now()
+		if doubleClick {
+			// A third click starts over
+			m.lastClickAt = time.Time{}
+		}
+		m.logger.Debugf("Mouse click on row %d, double=%v", row, doubleClick)
+
+		if m.searchMode {
+			m.searchService.MoveSelection(row - m.searchService.GetIndex())
+			if doubleClick {
+				return m, m.copyCommand(m.searchService.GetSelectedCommand())
+			}
+			return m, nil
+		}
+		m = m.moveHistorySelection(row - m.historySelectedIndex)
+		if history := m.historyUI.service.GetRanked(); doubleClick && row < len(history) {
+			return m, m.copyCommand(&history[row])
+		}
+		return m, nil
+	default:
+		return m, nil
+	}
+
+	if m.searchMode {
+		m.searchService.MoveSelection(delta)
+		return m, nil
+	}
+	return m.moveHistorySelection(delta), nil
+}
+
+// isCountDigit reports whether a key continues a count prefix. A 0 only
+// does after another digit.
+func isCountDigit(msg tea.KeyMsg, count int) bool {
+	if msg.Type != tea.KeyRunes || msg.Alt || len(msg.Runes) != 1 {
+		return false
+	}
+	r := msg.Runes[0]
+	return r >= '1' && r <= '9' || r == '0' && count > 0
+}
+
+// maxCount bounds count prefixes
+const maxCount = 99999
+
+// confirmSearch leaves the search for the history, selecting the chosen
+// result there. The search is kept for jumpToMatch.
+func (m Model) confirmSearch() Model {
+	m.logger.Info("Confirming search")
+	m.searchMode = false
+	m.historyUI.ClearSearchInput()
+	if m.searchService.GetQuery() == "" {
+		m.searchService.Clear()
+	}
+	if selected := m.searchService.GetSelectedCommand(); selected != nil {
+		if i := findCommand(slices.All(m.historyUI.service.GetRanked()), *selected); i >= 0 {
+			m.historySelectedIndex = i
+		}
+	}
+	return m
+}
+
+// jumpToMatch selects the next match of the last search, or the previous
+// one for a negative delta, delta times, wrapping around the history
+func (m Model) jumpToMatch(delta int) (tea.Model, tea.Cmd) {
+	if m.searchService.GetQuery() == "" {
+		return m, m.showStatus("❌ No search to repeat")
+	}
+	rows := m.searchService.GetMatchRows()
+	if len(rows) == 0 {
+		return m, m.showStatus("❌ No matches for " + m.searchService.GetQuery())
+	}
+
+	row := m.historySelectedIndex
+	for ; delta > 0; delta-- {
+		i, found := slices.BinarySearch(rows, row)
+		if found {
+			i++
+		}
+		row = rows[i%len(rows)]
+	}
+	for ; delta < 0; delta++ {
+		i, _ := slices.BinarySearch(rows, row)
+		row = rows[(i-1+len(rows))%len(rows)]
+	}
+	return m.moveHistorySelection(row - m.historySelectedIndex), nil
+}
+
+// hasSearch reports whether search results are in use: while searching, or
+// after a search was confirmed with a modal keymap
+func (m Model) hasSearch() bool {
+	return m.searchMode || m.searchService.GetQuery() != ""
+}
+
+// historyStatus describes the pending count and the search that
+// jumpToMatch steps through, for the history view
+func (m Model) historyStatus() string {
+	var parts []string
+	if query := m.searchService.GetQuery(); query != "" && !m.searchMode {
+		parts = append(parts, fmt.Sprintf("/%s · %d matches", query, m.searchService.GetResultCount()))
+	}
+	switch {
+	case m.count > 0 && m.pendingG:
+		parts = append(parts, fmt.Sprintf("%dg", m.count))
+	case m.count > 0:
+		parts = append(parts, fmt.Sprint(m.count))
+	case m.pendingG:
+		parts = append(parts, "g")
+	}
+	return strings.Join(parts, " · ")
+}
+
+// copyCommand copies a command to the clipboard and reports how it went, or
+// picks it when picking
+func (m Model) copyCommand(cmd *FishCommand) tea.Cmd {
+	if cmd == nil {
+		return nil
+	}
+	if m.pick {
+		command := cmd.Command
+		return func() tea.Msg {
+			return pickedMsg{command: command}
+		}
+	}
+	if err := clipboard.WriteAll(cmd.Command); err != nil {
+		m.logger.Errorf("Failed to copy to clipboard: %v", err)
+		return m.showStatus("❌ Copy failed")
+	}
+	m.logger.Infof("Copied command to clipboard: %s", cmd.Command)
+	return m.showStatus("✅ Copied to clipboard")
+}
+
+// updateSearchInput passes a message to the search input and searches again
+// when it changed the query
+func (m Model) updateSearchInput(msg tea.Msg) (tea.Model, tea.Cmd) {
+	input, cmd := m.historyUI.GetSearchInput().Update(msg)
+	m.historyUI.UpdateSearchInput(input)
+	if input.Value() == m.searchService.GetQuery() {
+		return m, cmd
+	}
+	return m, tea.Batch(cmd, m.searchService.UpdateQuery(m.historyUI.service.GetRanked(), input.Value()))
+}
+
+// moveHistorySelection moves the selection of the history view by delta
+// commands, stopping at the first and last command
+func (m Model) moveHistorySelection(delta int) Model {
+	last := len(m.historyUI.service.GetRanked()) - 1
+	m.historySelectedIndex = max(min(m.historySelectedIndex+delta, last), 0)
+	m.logger.Debugf("History navigation: index=%d", m.historySelectedIndex)
+	return m
+}
+
+// cycleRankMode switches to the next rank mode and re-orders the history and
+// the search results
+func (m Model) cycleRankMode() (tea.Model, tea.Cmd) {
+	mode := m.historyUI.service.CycleRankMode()
+	m.searchService.SetRankMode(mode)
+	m.historySelectedIndex = 0
+	status := m.showStatus("⇅ Sorted by " + mode.String())
+	if m.hasSearch() {
+		return m, tea.Batch(status, m.searchService.UpdateQuery(m.historyUI.service.GetRanked(), m.searchService.GetQuery()))
+	}
+	return m, status
+}
+
+// toggleGrouping switches between listing every run and listing identical
+// commands once
+func (m Model) toggleGrouping() (tea.Model, tea.Cmd) {
+	if m.historyUI.service.GetRankMode() != RankRecency {
+		return m, m.showStatus("Commands are always grouped when not sorted by recency")
+	}
+	grouped := !m.historyUI.service.IsGrouped()
+	m.historyUI.service.SetGrouped(grouped)
+	m.historySelectedIndex = 0
+	status := m.showStatus("Showing every run")
+	if grouped {
+		status = m.showStatus("Grouping identical commands")
+	}
+	if m.hasSearch() {
+		return m, tea.Batch(status, m.searchService.UpdateQuery(m.historyUI.service.GetRanked(), m.searchService.GetQuery()))
+	}
+	return m, status
+}
+
+// updateErrorScreen handles keys while the load error is shown
+func (m Model) updateErrorScreen(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.editingPath {
+		// Printable keys are typed into the path even when bound
+		if msg.Type == tea.KeyRunes && !msg.Alt {
+			var cmd tea.Cmd
+			m.pathInput, cmd = m.pathInput.Update(msg)
+			return m, cmd
+		}
+		switch {
+		case key.Matches(msg, m.keys.Quit):
+			m.logger.Info("Quit command received")
+			return m.quit()
+		case key.Matches(msg, m.keys.Back):
+			m.editingPath = false
+			m.pathInput.Blur()
+			return m, nil
+		case key.Matches(msg, m.keys.Select):
+			path := expandHome(strings.TrimSpace(m.pathInput.Value()))
+			if path == "" {
 				return m, nil
-			default:
-				// Auto-enter search mode when typing
-				if len(key) == 1 {
-					m.logger.Info("Auto-entering search mode")
-					m.searchMode = true
-					// Initialize with empty query to show last 5 commands
-					m.searchService.UpdateQuery(m.historyUI.service.GetHistory(), "")
-					// Add the typed character to the search query
-					m.searchService.UpdateQuery(m.historyUI.service.GetHistory(), key)
-					return m, nil
-				}
 			}
+			current := m.historyUI.service.GetSource()
+			source, err := NewHistorySource(current.Name(), path, current.Label())
+			if err != nil {
+				m.logger.Errorf("Failed to create history source: %v", err)
+				return m, m.showStatus("❌ Could not use that path")
+			}
+			m.logger.Infof("Loading history from entered path %s", path)
+			m.editingPath = false
+			m.pathInput.Blur()
+			m.historyUI.service.SetSource(source)
+			return m, m.historyUI.StartLoading()
+		}
+		var cmd tea.Cmd
+		m.pathInput, cmd = m.pathInput.Update(msg)
+		return m, cmd
+	}
+
+	switch {
+	case key.Matches(msg, m.keys.Back), key.Matches(msg, m.keys.Quit):
+		m.logger.Info("Quit command received")
+		return m.quit()
+	case key.Matches(msg, m.keys.Retry):
+		m.logger.Info("Retrying history load")
+		return m, m.historyUI.StartLoading()
+	case key.Matches(msg, m.keys.EditPath):
+		m.editingPath = true
+		m.pathInput.SetValue(m.historyUI.service.GetSource().Path())
+		m.pathInput.CursorEnd()
+		return m, m.pathInput.Focus()
+	}
+	return m, nil
+}
+
+// openSessionPicker lists the fish history sessions to choose from
+func (m Model) openSessionPicker() (tea.Model, tea.Cmd) {
+	if m.historyUI.service.GetSource().Name() != ShellFish {
+		return m, m.showStatus("❌ Sessions are only available for fish history")
+	}
+
+	sessions, err := m.discovery.ListSessions()
+	if err != nil {
+		m.logger.Errorf("Failed to list history sessions: %v", err)
+		return m, m.showStatus("❌ Could not list history sessions")
+	}
+	if len(sessions) == 0 {
+		return m, m.showStatus("❌ No history sessions found")
+	}
+
+	m.logger.Infof("Opening session picker with %d sessions", len(sessions))
+	m.sessionMode = true
+	m.sessions = sessions
+	m.sessionIndex = 0
+	for i, session := range sessions {
+		if session.Path == m.historyUI.service.GetSource().Path() {
+			m.sessionIndex = i
 		}
 	}
 	return m, nil
 }
 
+// updateSessionPicker handles keys while the session picker is open
+func (m Model) updateSessionPicker(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch {
+	case key.Matches(msg, m.keys.Quit):
+		m.logger.Info("Quit command received")
+		return m.quit()
+	case key.Matches(msg, m.keys.Back), key.Matches(msg, m.keys.Sessions):
+		m.sessionMode = false
+		return m, nil
+	case key.Matches(msg, m.keys.Up):
+		if m.sessionIndex > 0 {
+			m.sessionIndex--
+		}
+		return m, nil
+	case key.Matches(msg, m.keys.Down):
+		if m.sessionIndex < len(m.sessions)-1 {
+			m.sessionIndex++
+		}
+		return m, nil
+	case key.Matches(msg, m.keys.Select):
+		session := m.sessions[m.sessionIndex]
+		m.logger.Infof("Switching to history session %s (%s)", session.Name, session.Path)
+		m.sessionMode = false
+		m.searchMode = false
+		m.searchService.Clear()
+		m.historyUI.ClearSearchInput()
+		m.historySelectedIndex = 0
+		source, err := NewHistorySource(ShellFish, session.Path, m.historyUI.service.GetSource().Label())
+		if err != nil {
+			m.logger.Errorf("Failed to open history session: %v", err)
+			return m, m.showStatus("❌ Could not open session")
+		}
+		m.historyUI.service.SetSource(source)
+		return m, m.historyUI.StartLoading()
+	}
+	return m, nil
+}
+
+// View returns the view rendered by the last update
 func (m Model) View() string {
+	return m.view
+}
+
+// layout renders the view, working out where its list goes, see listLayout
+func (m Model) layout() string {
+	var layout listLayout
+	view := m.render(&layout)
+	m.historyUI.SetLayout(layout, lipgloss.Height(view))
+	return view
+}
+
+// render renders the view, laying out its list into layout unless it is nil
+func (m Model) render(layout *listLayout) string {
+	if m.inlineHeight > 0 {
+		return m.inlineView(layout)
+	}
+
 	var content string
-	if m.searchMode {
-		content = m.historyUI.RenderSearchView(m.searchService.GetQuery(), m.searchService.GetResults(), m.searchService.GetIndex())
+	if m.showHelp {
+		content = m.historyUI.RenderHelpView()
+	} else if m.sessionMode {
+		content = m.historyUI.RenderSessionView(m.sessions, m.sessionIndex)
+	} else if err := m.historyUI.service.GetLoadError(); err != nil {
+		content = m.historyUI.RenderErrorView(err, m.pathInput, m.editingPath)
+	} else if m.searchMode {
+		content = m.historyUI.RenderSearchView(m.searchService.GetQuery(), m.searchService.GetResult, m.searchService.GetResultCount(), m.searchService.GetIndex(), m.searchService.GetMatchMode(), m.searchService.GetQueryError(), m.searchService.IsSearching(), layout)
 	} else {
-		content = m.historyUI.RenderHistoryView(m.historySelectedIndex)
+		content = m.historyUI.RenderHistoryView(m.historySelectedIndex, m.historyStatus(), layout)
 	}
 
 	// Add status message if present - positioned at the bottom
 	if m.statusMessage != "" {
 		content += "\n\n" + m.historyUI.RenderStatusMessage(m.statusMessage)
 	}
-
 	return content
 }
 
-func NewApp(logger *LoggerService) *Model {
-	historyService := NewFishHistoryService(logger)
-	historyUI := NewFishHistoryUI(historyService, logger)
+// inlineView renders the interface in inline mode. The history and the search
+// results get compact views; the other screens are cut to the height.
+func (m Model) inlineView(layout *listLayout) string {
+	if m.quitting {
+		// Leave the prompt as it was
+		return ""
+	}
+
+	height := lipgloss.NewStyle().MaxHeight(m.historyUI.height)
+	switch {
+	case m.showHelp:
+		return height.Render(m.historyUI.RenderHelpView())
+	case m.sessionMode:
+		return height.Render(m.historyUI.RenderSessionView(m.sessions, m.sessionIndex))
+	}
+	if err := m.historyUI.service.GetLoadError(); err != nil {
+		return height.Render(m.historyUI.RenderErrorView(err, m.pathInput, m.editingPath))
+	}
+
+	var info []string
+	if m.searchMode {
+		info = append(info, "["+m.searchService.GetMatchMode().String()+"]")
+		if m.searchService.IsSearching() {
+			info = append(info, "searching…")
+		}
+		if err := m.searchService.GetQueryError(); err != nil {
+			info = append(info, "❌ "+err.Error())
+		}
+	} else if status := m.historyStatus(); status != "" {
+		info = append(info, status)
+	}
+	if m.statusMessage != "" {
+		info = append(info, m.statusMessage)
+	}
+
+	if m.searchMode {
+		return m.historyUI.RenderInlineView(m.searchService.GetResult, m.searchService.GetResultCount(), m.searchService.GetIndex(), strings.Join(info, " · "), layout)
+	}
+	history := m.historyUI.service.GetRanked()
+	return m.historyUI.RenderInlineView(func(i int) SearchResult {
+		return SearchResult{FishCommand: history[i]}
+	}, len(history), m.historySelectedIndex, strings.Join(info, " · "), layout)
+}
+
+// Picked returns the command chosen when picking, if any
+func (m Model) Picked() string {
+	return m.picked
+}
+
+func NewApp(logger *LoggerService, discovery *HistoryDiscoveryService, source HistorySource, archives []HistorySource, options AppOptions) *Model {
+	if options.Pick {
+		options.Keys.Copy = withDesc(options.Keys.Copy, "pick")
+		options.Keys.Yank = withDesc(options.Keys.Yank, "pick")
+	}
+	historyService := NewFishHistoryService(logger, source, archives)
+	historyService.SetRankMode(options.Rank)
+	historyService.SetGrouped(options.Group)
+	historyService.SetNormalizeWhitespace(options.NormalizeWhitespace)
+	historyUI := NewFishHistoryUI(historyService, options.Keys, logger)
+	historyUI.SetInline(options.Height > 0)
 	searchService := NewSearchService(logger)
-	return &Model{
+	searchService.SetDebounce(options.Debounce)
+	searchService.SetRankMode(options.Rank)
+
+	pathInput := textinput.New()
+	pathInput.Placeholder = "~/.local/share/fish/fish_history"
+	pathInput.TextStyle = commandTextStyle
+	pathInput.PlaceholderStyle = timestampStyle
+	pathInput.Cursor.Style = lipgloss.NewStyle().Foreground(primaryColor)
+	pathInput.Width = 50
+
+	m := &Model{
 		logger:        logger,
 		historyUI:     historyUI,
 		searchService: searchService,
+		discovery:     discovery,
 		searchMode:    false,
+		follow:        options.Follow,
+		keys:          options.Keys,
+		pick:          options.Pick,
+		inlineHeight:  options.Height,
+		pathInput:     pathInput,
 	}
+	m.view = m.layout()
+	return m
 }
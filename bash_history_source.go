@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BashHistorySource reads bash's ~/.bash_history.
+//
+// With HISTTIMEFORMAT set bash writes a `#<epoch>` comment line before every
+// command. In that layout every line up to the next timestamp belongs to the
+// same command, which is how bash stores multi-line commands with lithist.
+// Without timestamps each line is a command of its own and has no time.
+type BashHistorySource struct {
+	path  string
+	label string
+}
+
+// Name returns the shell the source reads
+func (s *BashHistorySource) Name() string {
+	return ShellBash
+}
+
+// Path returns the history file the source reads
+func (s *BashHistorySource) Path() string {
+	return s.path
+}
+
+// Label returns the origin recorded on the source's entries
+func (s *BashHistorySource) Label() string {
+	return s.label
+}
+
+// Decode parses bash history from r
+func (s *BashHistorySource) Decode(r io.Reader, emit func(FishCommand)) ([]ParseDiagnostic, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryLineSize)
+
+	var diagnostics []ParseDiagnostic
+	var current *FishCommand
+	var when time.Time
+	var timestamped bool
+	line := 0
+
+	flush := func() {
+		if current != nil {
+			current.Command = strings.TrimRight(current.Command, "\n")
+			emit(*current)
+			current = nil
+		}
+	}
+
+	for scanner.Scan() {
+		line++
+		text := strings.TrimRight(scanner.Text(), "\r")
+
+		if timestamp, ok := parseBashTimestamp(text); ok {
+			flush()
+			when = time.Unix(timestamp, 0)
+			timestamped = true
+			continue
+		}
+
+		if current != nil && timestamped {
+			current.Command += "\n" + text
+			continue
+		}
+
+		flush()
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		if timestamped && when.IsZero() {
+			diagnostics = append(diagnostics, ParseDiagnostic{Line: line, Reason: "command without a timestamp"})
+		}
+		current = &FishCommand{Command: text, When: when}
+		when = time.Time{}
+	}
+	flush()
+
+	return diagnostics, scanner.Err()
+}
+
+// Finished returns the complete lines of data, up to the last timestamp:
+// with timestamps a command runs until the next one, so it may still grow
+func (s *BashHistorySource) Finished(data []byte) int {
+	end := bytes.LastIndexByte(data, '\n') + 1
+	finished := end
+	for start := 0; start < end; {
+		next := start + bytes.IndexByte(data[start:end], '\n') + 1
+		line := strings.TrimRight(string(data[start:next-1]), "\r")
+		if _, ok := parseBashTimestamp(line); ok {
+			finished = start
+		}
+		start = next
+	}
+	return finished
+}
+
+// parseBashTimestamp recognises the `#<epoch>` lines HISTTIMEFORMAT produces
+func parseBashTimestamp(line string) (int64, bool) {
+	digits, ok := strings.CutPrefix(line, "#")
+	if !ok || digits == "" {
+		return 0, false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return 0, false
+		}
+	}
+	timestamp, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return timestamp, true
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// decodeAll runs a source over input and collects what it emits
+func decodeAll(t *testing.T, source HistorySource, input string) ([]FishCommand, []ParseDiagnostic) {
+	t.Helper()
+	var commands []FishCommand
+	diagnostics, err := source.Decode(strings.NewReader(input), func(cmd FishCommand) {
+		commands = append(commands, cmd)
+	})
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	return commands, diagnostics
+}
+
+// historyEntry is a decoded command and its time in seconds, 0 for none
+type historyEntry struct {
+	command string
+	unix    int64
+}
+
+func historyEntries(commands []FishCommand) []historyEntry {
+	var entries []historyEntry
+	for _, cmd := range commands {
+		entry := historyEntry{command: cmd.Command}
+		if !cmd.When.IsZero() {
+			entry.unix = cmd.When.Unix()
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func diagnosticLines(diagnostics []ParseDiagnostic) []int {
+	var lines []int
+	for _, diagnostic := range diagnostics {
+		lines = append(lines, diagnostic.Line)
+	}
+	return lines
+}
+
+func TestBashHistoryDecode(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []historyEntry
+	}{
+		{
+			name:  "plain lines",
+			input: "ls\n\ncd /tmp\r\nmake\n",
+			want:  []historyEntry{{"ls", 0}, {"cd /tmp", 0}, {"make", 0}},
+		},
+		{
+			name:  "timestamps",
+			input: "#1700000000\nls\n#1700000060\nmake test\n",
+			want:  []historyEntry{{"ls", 1700000000}, {"make test", 1700000060}},
+		},
+		{
+			name:  "multi-line command up to the next timestamp",
+			input: "#1700000000\nfor f in *\ndo echo $f\ndone\n\n#1700000060\npwd\n",
+			want:  []historyEntry{{"for f in *\ndo echo $f\ndone", 1700000000}, {"pwd", 1700000060}},
+		},
+		{
+			name:  "comments that are not timestamps are commands",
+			input: "#not a time\n#12a\n",
+			want:  []historyEntry{{"#not a time", 0}, {"#12a", 0}},
+		},
+		{
+			name:  "timestamp without a command",
+			input: "#1700000000\nls\n#1700000060\n#1700000120\npwd\n",
+			want:  []historyEntry{{"ls", 1700000000}, {"pwd", 1700000120}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, diagnostics := decodeAll(t, &BashHistorySource{}, tt.input)
+			if got := historyEntries(commands); !slices.Equal(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+			if len(diagnostics) > 0 {
+				t.Errorf("unexpected diagnostics %v", diagnostics)
+			}
+		})
+	}
+}
+
+func TestParseBashTimestamp(t *testing.T) {
+	tests := []struct {
+		line   string
+		want   int64
+		wantOK bool
+	}{
+		{"#1700000000", 1700000000, true},
+		{"#0", 0, true},
+		{"#", 0, false},
+		{"1700000000", 0, false},
+		{"#-1", 0, false},
+		{"# 1700000000", 0, false},
+		{"#99999999999999999999", 0, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := parseBashTimestamp(tt.line)
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("parseBashTimestamp(%q) = %d, %t, want %d, %t", tt.line, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Config holds the user settings read from the config file
+type Config struct {
+	// Shell selects the history format: fish, bash or zsh
+	Shell string `json:"shell"`
+	// HistoryPath points at the history file to load instead of the
+	// auto-discovered one
+	HistoryPath string `json:"history_path"`
+	// Archives lists extra history files, for example copied over from other
+	// machines, that are merged into the timeline
+	Archives []SourceConfig `json:"archives"`
+	// Follow watches the history file for new commands; on unless set to false
+	Follow *bool `json:"follow"`
+	// SearchDebounceMS waits this many milliseconds after the last keystroke
+	// before searching; 0 searches on every keystroke
+	SearchDebounceMS int `json:"search_debounce_ms"`
+	// Rank orders the history: recency, frecency, frequency or alphabetical
+	Rank string `json:"rank"`
+	// Group lists identical commands once with their run count
+	Group bool `json:"group"`
+	// NormalizeWhitespace groups commands that only differ in spacing
+	NormalizeWhitespace bool `json:"normalize_whitespace"`
+	// Theme names the color theme: auto, dark, light or one of Themes
+	Theme string `json:"theme"`
+	// Themes defines custom color themes by name
+	Themes map[string]Theme `json:"themes"`
+	// Height draws the interface in at most this many lines under the prompt
+	// instead of filling the window
+	Height int `json:"height"`
+	// Mouse scrolls, selects and copies commands with the mouse
+	Mouse bool `json:"mouse"`
+	// Keymap names the key bindings to start from: default or vim
+	Keymap string `json:"keymap"`
+	// Keys rebinds actions, such as "copy", to lists of keys
+	Keys map[string][]string `json:"keys"`
+}
+
+// SourceConfig describes one history file to read
+type SourceConfig struct {
+	Shell string `json:"shell"`
+	Path  string `json:"path"`
+	// Label names the origin shown on the file's entries, such as a host
+	Label string `json:"label"`
+}
+
+// DefaultConfigPath returns $XDG_CONFIG_HOME/bublsrc/config.json, falling back
+// to ~/.config when XDG_CONFIG_HOME is not set
+func DefaultConfigPath() (string, error) {
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		configHome = filepath.Join(homeDir, ".config")
+	}
+	return filepath.Join(configHome, "bublsrc", "config.json"), nil
+}
+
+// DefaultLogPath returns the log file used by pick, which runs in whatever
+// directory the shell is in: $XDG_STATE_HOME/bublsrc/debug.log
+func DefaultLogPath() (string, error) {
+	stateHome := os.Getenv("XDG_STATE_HOME")
+	if stateHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		stateHome = filepath.Join(homeDir, ".local", "state")
+	}
+	return filepath.Join(stateHome, "bublsrc", "debug.log"), nil
+}
+
+// LoadConfig reads the config file at path. A missing file is not an error
+// and yields the zero Config.
+func LoadConfig(path string) (Config, error) {
+	var config Config
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return config, nil
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse config %s: %w", path, err)
+	}
+	config.HistoryPath = expandHome(config.HistoryPath)
+	for i := range config.Archives {
+		config.Archives[i].Path = expandHome(config.Archives[i].Path)
+	}
+	return config, nil
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, strings.TrimPrefix(path, "~"))
+}
@@ -1,124 +1,256 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/charmbracelet/bubbles/help"
+	"github.com/charmbracelet/bubbles/key"
 	"github.com/charmbracelet/bubbles/list"
 	"github.com/charmbracelet/bubbles/textinput"
 	"github.com/charmbracelet/bubbles/viewport"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
 )
 
-// Define beautiful styles using LipGloss
+// Define beautiful styles using LipGloss. They are built from the active
+// theme by applyTheme.
 var (
 	// Colors
-	primaryColor   = lipgloss.Color("#00D4AA") // Teal
-	secondaryColor = lipgloss.Color("#7C3AED") // Purple
-	accentColor    = lipgloss.Color("#F59E0B") // Amber
-	textColor      = lipgloss.Color("#F8FAFC") // Light gray
-	mutedColor     = lipgloss.Color("#64748B") // Slate
-	errorColor     = lipgloss.Color("#EF4444") // Red
-	successColor   = lipgloss.Color("#10B981") // Green
+	primaryColor, secondaryColor, accentColor, textColor lipgloss.Color
+	mutedColor, errorColor, successColor                 lipgloss.Color
+
+	headerStyle, titleStyle                               lipgloss.Style
+	commandStyle, commandNumberStyle, commandTextStyle    lipgloss.Style
+	matchStyle                                            lipgloss.Style
+	syntaxStyles                                          map[TokenKind]lipgloss.Style
+	timestampStyle, pathStyle, originStyle                lipgloss.Style
+	searchBoxStyle, searchPromptStyle                     lipgloss.Style
+	listStyle, selectedItemStyle                          lipgloss.Style
+	statusStyle, statusMessageStyle, statusErrorStyle     lipgloss.Style
+	loadingStyle, progressFilledStyle, progressEmptyStyle lipgloss.Style
+	helpStyle, keyStyle                                   lipgloss.Style
+	previewStyle, previewLabelStyle                       lipgloss.Style
+	containerStyle                                        lipgloss.Style
+)
+
+func init() {
+	applyTheme(darkTheme)
+}
+
+// applyTheme rebuilds every style from the colors of theme
+func applyTheme(theme Theme) {
+	// Colors
+	primaryColor = theme.Primary
+	secondaryColor = theme.Secondary
+	accentColor = theme.Accent
+	textColor = theme.Text
+	mutedColor = theme.Muted
+	errorColor = theme.Error
+	successColor = theme.Success
 
 	// Header styles
 	headerStyle = lipgloss.NewStyle().
-			Foreground(primaryColor).
-			Bold(true).
-			Margin(1, 0).
-			Align(lipgloss.Center)
+		Foreground(primaryColor).
+		Bold(true).
+		Margin(1, 0).
+		Align(lipgloss.Center)
 
 	titleStyle = lipgloss.NewStyle().
-			Foreground(primaryColor).
-			Bold(true).
-			Margin(0, 1)
+		Foreground(primaryColor).
+		Bold(true).
+		Margin(0, 1)
 
 	// Command styles
 	commandStyle = lipgloss.NewStyle().
-			Foreground(textColor).
-			Margin(0, 2)
+		Foreground(textColor).
+		Margin(0, 2)
 
 	commandNumberStyle = lipgloss.NewStyle().
-				Foreground(accentColor).
-				Bold(true)
+		Foreground(accentColor).
+		Bold(true)
 
 	commandTextStyle = lipgloss.NewStyle().
-				Foreground(textColor)
+		Foreground(textColor)
+
+	// matchStyle marks the characters matching the query on top of the
+	// syntax colors, see highlightCommand
+	matchStyle = lipgloss.NewStyle().
+		Background(theme.MatchBackground).
+		Bold(true).
+		Underline(true)
+
+	// Shell syntax styles, by TokenKind
+	syntaxStyles = map[TokenKind]lipgloss.Style{
+		TokenText:     commandTextStyle,
+		TokenCommand:  lipgloss.NewStyle().Foreground(primaryColor).Bold(true),
+		TokenFlag:     lipgloss.NewStyle().Foreground(theme.Flag),
+		TokenString:   lipgloss.NewStyle().Foreground(successColor),
+		TokenVariable: lipgloss.NewStyle().Foreground(theme.Variable),
+		TokenOperator: lipgloss.NewStyle().Foreground(accentColor).Bold(true),
+		TokenComment:  lipgloss.NewStyle().Foreground(mutedColor).Italic(true),
+	}
 
 	timestampStyle = lipgloss.NewStyle().
-			Foreground(mutedColor).
-			Italic(true)
+		Foreground(mutedColor).
+		Italic(true)
+
+	pathStyle = lipgloss.NewStyle().
+		Foreground(secondaryColor)
+
+	originStyle = lipgloss.NewStyle().
+		Foreground(primaryColor).
+		Italic(true)
 
 	// Search styles
 	searchBoxStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(primaryColor).
-			Padding(0, 1).
-			Margin(1, 0)
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(primaryColor).
+		Padding(0, 1).
+		Margin(1, 0)
 
 	searchPromptStyle = lipgloss.NewStyle().
-				Foreground(accentColor).
-				Bold(true)
+		Foreground(accentColor).
+		Bold(true)
 
 	// List styles
 	listStyle = lipgloss.NewStyle().
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(secondaryColor).
-			Padding(1, 2).
-			Margin(1, 0)
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(secondaryColor).
+		Padding(1, 2).
+		Margin(1, 0)
 
 	selectedItemStyle = lipgloss.NewStyle().
-				Foreground(primaryColor).
-				Bold(true).
-				Background(lipgloss.Color("#1E293B")).
-				Padding(0, 1)
+		Foreground(primaryColor).
+		Bold(true).
+		Background(theme.Selection).
+		Padding(0, 1)
 
 	// Status styles
 	statusStyle = lipgloss.NewStyle().
-			Foreground(mutedColor).
-			Italic(true).
-			Margin(1, 0)
+		Foreground(mutedColor).
+		Italic(true).
+		Margin(1, 0)
 
 	// Status message styles - more subtle and elegant
 	statusMessageStyle = lipgloss.NewStyle().
-				Foreground(successColor).
-				Bold(true).
-				Background(lipgloss.Color("#0F172A")).
-				Padding(0, 1).
-				Margin(0, 2).
-				Align(lipgloss.Center)
+		Foreground(successColor).
+		Bold(true).
+		Background(theme.StatusBackground).
+		Padding(0, 1).
+		Margin(0, 2).
+		Align(lipgloss.Center)
 
 	statusErrorStyle = lipgloss.NewStyle().
-				Foreground(errorColor).
-				Bold(true).
-				Background(lipgloss.Color("#0F172A")).
-				Padding(0, 1).
-				Margin(0, 2).
-				Align(lipgloss.Center)
+		Foreground(errorColor).
+		Bold(true).
+		Background(theme.StatusBackground).
+		Padding(0, 1).
+		Margin(0, 2).
+		Align(lipgloss.Center)
 
 	loadingStyle = lipgloss.NewStyle().
-			Foreground(accentColor).
-			Bold(true).
-			Margin(1, 0)
+		Foreground(accentColor).
+		Bold(true).
+		Margin(1, 0)
+
+	progressFilledStyle = lipgloss.NewStyle().
+		Foreground(primaryColor)
+
+	progressEmptyStyle = lipgloss.NewStyle().
+		Foreground(mutedColor)
 
 	// Help styles
 	helpStyle = lipgloss.NewStyle().
-			Foreground(mutedColor).
-			Margin(1, 0)
+		Foreground(mutedColor).
+		Margin(1, 0)
 
 	keyStyle = lipgloss.NewStyle().
-			Foreground(accentColor).
-			Bold(true)
+		Foreground(accentColor).
+		Bold(true)
+
+	// Preview styles
+	previewStyle = lipgloss.NewStyle().
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(mutedColor).
+		Padding(0, 1)
+
+	previewLabelStyle = lipgloss.NewStyle().
+		Foreground(accentColor).
+		Bold(true)
 
 	// Container styles
 	containerStyle = lipgloss.NewStyle().
-			Padding(1, 2).
-			Border(lipgloss.RoundedBorder()).
-			BorderForeground(primaryColor).
-			Margin(1, 0)
+		Padding(1, 2).
+		Border(lipgloss.RoundedBorder()).
+		BorderForeground(primaryColor).
+		Margin(1, 0)
+}
+
+// Layout limits of the list views
+const (
+	// statusLineHeight is kept free below the container for status messages
+	statusLineHeight = 2
+	minListHeight    = 3
+	minListWidth     = 20
+	// searchInputReserve is the width next to the search input taken by its
+	// prompt, box and match mode
+	searchInputReserve  = 40
+	minSearchInputWidth = 10
+	// previewSplitWidth is the inner width from which the preview is shown
+	// next to the list unless toggled off; narrower windows show it below
+	// the list when toggled on
+	previewSplitWidth = 110
+	previewGap        = 2
+	// previewNeighbors is how many commands run before and after the
+	// selected one the preview lists, and previewRuns how many of its runs
+	previewNeighbors = 3
+	previewRuns      = 5
+)
+
+// previewLayout tells where the preview of the selected command goes
+type previewLayout int
+
+const (
+	previewHidden previewLayout = iota
+	previewSide
+	previewBelow
 )
 
+// rowSpan is the lines a row of the list takes, from top up to bottom,
+// counted from the top of the list
+type rowSpan struct {
+	index       int
+	top, bottom int
+}
+
+// listLayout is where a view puts its list: the first row on screen, how
+// many rows fit, where on screen the list is and the rows in it. It is
+// worked out by rendering with a layout to fill after every update, so that
+// View only draws and keys and clicks act on what is on screen.
+type listLayout struct {
+	offset, pageSize    int
+	x, y, width, height int
+	rows                []rowSpan
+	// clippedLines is how many lines the terminal cut off the top of a view
+	// taller than itself
+	clippedLines int
+}
+
+// place records where on screen the list goes, given the lines of the view
+// above it. The list starts at the left of the container.
+func (l *listLayout) place(linesAbove int) {
+	if l == nil {
+		return
+	}
+	l.x = containerStyle.GetMarginLeft() + containerStyle.GetBorderLeftSize() + containerStyle.GetPaddingLeft()
+	l.y = containerStyle.GetMarginTop() + containerStyle.GetBorderTopSize() + containerStyle.GetPaddingTop() + linesAbove
+}
+
 // FishHistoryUI handles fish history specific UI operations
 type FishHistoryUI struct {
 	service     *FishHistoryService
@@ -128,17 +260,41 @@ type FishHistoryUI struct {
 	width       int
 	height      int
 	logger      *LoggerService
+	// expanded holds the commands whose every run is listed
+	expanded map[string]bool
+	// layout is where the list of the current view is, see SetLayout
+	layout listLayout
+	// previewToggled flips whether the preview is shown at the current width
+	previewToggled bool
+	keys           KeyMap
+	help           help.Model
+	// inline draws compact views under the prompt instead of filling the
+	// window, see RenderInlineView
+	inline bool
 }
 
 // NewFishHistoryUI creates a new fish history UI component
-func NewFishHistoryUI(service *FishHistoryService, logger *LoggerService) *FishHistoryUI {
+func NewFishHistoryUI(service *FishHistoryService, keys KeyMap, logger *LoggerService) *FishHistoryUI {
 	// Initialize search input
 	searchInput := textinput.New()
+	searchInput.Prompt = ""
 	searchInput.Placeholder = "Search commands..."
+	searchInput.TextStyle = commandTextStyle
+	searchInput.PlaceholderStyle = timestampStyle
+	searchInput.Cursor.Style = lipgloss.NewStyle().Foreground(primaryColor)
 	searchInput.Focus()
-	searchInput.CharLimit = 100
 	searchInput.Width = 50
 
+	// Initialize help, styled like the rest of the interface
+	h := help.New()
+	h.Styles.ShortKey = keyStyle
+	h.Styles.ShortDesc = helpStyle.UnsetMargins()
+	h.Styles.ShortSeparator = helpStyle.UnsetMargins()
+	h.Styles.FullKey = keyStyle
+	h.Styles.FullDesc = helpStyle.UnsetMargins()
+	h.Styles.FullSeparator = helpStyle.UnsetMargins()
+	h.Styles.Ellipsis = helpStyle.UnsetMargins()
+
 	// Initialize viewport
 	vp := viewport.New(80, 20)
 
@@ -156,156 +312,619 @@ func NewFishHistoryUI(service *FishHistoryService, logger *LoggerService) *FishH
 		width:       80,
 		height:      20,
 		logger:      logger,
+		expanded:    make(map[string]bool),
+		keys:        keys,
+		help:        h,
 	}
 }
 
-// RenderHistoryView renders the fish history view with beautiful styling
-func (ui *FishHistoryUI) RenderHistoryView(selectedIndex int) string {
-	if !ui.service.IsHistoryLoaded() {
-		loading := loadingStyle.Render("🔄 Loading fish history...")
-		help := helpStyle.Render("Press " + keyStyle.Render("Ctrl+C") + " to quit")
-		return containerStyle.Render(loading + "\n\n" + help)
+// RenderHistoryView renders the fish history view with beautiful styling.
+// status, if any, is shown next to the command count. The list is laid out
+// into layout unless it is nil.
+func (ui *FishHistoryUI) RenderHistoryView(selectedIndex int, status string, layout *listLayout) string {
+	if !ui.service.IsHistoryLoaded() && len(ui.service.GetHistory()) == 0 {
+		return ui.renderLoading()
 	}
 
-	// Create beautiful header
-	header := headerStyle.Render("🐟 Fish History")
-	subtitle := statusStyle.Render("Last 5 Commands")
-
-	// Get last 5 commands using service
-	lastCommands := ui.service.GetLastCommands(5)
+	history := ui.service.GetRanked()
 
-	// Create command list with beautiful styling
-	var commands []string
-	for i, cmd := range lastCommands {
-		var prefix string
-		if i == selectedIndex {
-			prefix = selectedItemStyle.Render("▶")
-		} else {
-			prefix = "  "
-		}
-
-		number := commandNumberStyle.Render(fmt.Sprintf("%d.", i+1))
-		command := commandTextStyle.Render(cmd.Command)
-		timestamp := timestampStyle.Render(cmd.When.Format("2006-01-02 15:04:05"))
-
-		commandLine := fmt.Sprintf("%s %s %s\n   %s", prefix, number, command, timestamp)
-		commands = append(commands, commandLine)
+	// Create beautiful header
+	header := headerStyle.Render(ui.historyTitle() + " " + ui.rankLabel())
+	subtitleText := fmt.Sprintf("%d commands", len(history))
+	if len(history) > 0 {
+		subtitleText += fmt.Sprintf(" · %d/%d", selectedIndex+1, len(history))
+	}
+	if status != "" {
+		subtitleText += " · " + status
+	}
+	subtitle := statusStyle.Render(subtitleText)
+	if ui.service.IsLoading() {
+		subtitle += "\n" + ui.renderProgress()
 	}
-
-	// Join commands with spacing
-	commandList := strings.Join(commands, "\n\n")
 
 	// Create help text
-	help := helpStyle.Render("Press " + keyStyle.Render("Ctrl+C") + " to quit, " + keyStyle.Render("↑/↓") + " or " + keyStyle.Render("Ctrl+J/K") + " to navigate, " + keyStyle.Render("Enter") + " to copy, " + keyStyle.Render("type") + " to search")
+	help := ui.renderHelp(ui.keys.HistoryShortHelp())
+
+	// Fill the rest of the window with the command list
+	height := ui.listHeight(2, header, subtitle, help)
+	var selected *FishCommand
+	if selectedIndex < len(history) {
+		selected = &history[selectedIndex]
+	}
+	commandList := ui.renderListWithPreview(len(history), selectedIndex, height, selected, nil, layout, func(i int, selected bool) string {
+		return ui.renderCommandRow(history[i], nil, i, selected)
+	})
+	layout.place(lipgloss.Height(header) + lipgloss.Height(subtitle) + 1)
 
 	// Combine everything
 	content := header + "\n" + subtitle + "\n\n" + commandList + "\n\n" + help
 
-	return containerStyle.Render(content)
+	return ui.renderContainer(content)
 }
 
-// RenderSearchView renders the search results view with beautiful styling
-func (ui *FishHistoryUI) RenderSearchView(query string, results []FishCommand, selectedIndex int) string {
-	ui.logger.Debugf("RenderSearchView: query='%s', results=%d, selectedIndex=%d", query, len(results), selectedIndex)
+// RenderSearchView renders the search results view with beautiful styling.
+// result returns the i-th of the totalCount results. The list is laid out
+// into layout unless it is nil.
+func (ui *FishHistoryUI) RenderSearchView(query string, result func(i int) SearchResult, totalCount int, selectedIndex int, matchMode MatchMode, queryErr error, searching bool, layout *listLayout) string {
+	ui.logger.Debugf("RenderSearchView: query='%s', results=%d, selectedIndex=%d", query, totalCount, selectedIndex)
 
-	if !ui.service.IsHistoryLoaded() {
-		loading := loadingStyle.Render("🔄 Loading fish history...")
-		help := helpStyle.Render("Press " + keyStyle.Render("Ctrl+C") + " to quit")
-		return containerStyle.Render(loading + "\n\n" + help)
+	if !ui.service.IsHistoryLoaded() && len(ui.service.GetHistory()) == 0 {
+		return ui.renderLoading()
 	}
 
 	// Create beautiful header
 	var header string
 	if query == "" {
-		header = headerStyle.Render("🐟 Fish History")
+		header = headerStyle.Render(ui.historyTitle() + " " + ui.rankLabel())
 	} else {
-		header = headerStyle.Render("🔍 Search Results")
+		header = headerStyle.Render("🔍 Search Results " + ui.rankLabel())
 	}
 
 	// Create search query display
-	var queryDisplay string
-	if query == "" {
-		queryDisplay = statusStyle.Render("Recent Commands")
-	} else {
-		queryDisplay = searchPromptStyle.Render("Query: ") + commandTextStyle.Render(query)
+	status := timestampStyle.Render("[" + matchMode.String() + "]")
+	if searching {
+		status += " " + timestampStyle.Render("searching…")
+	}
+	queryDisplay := lipgloss.JoinHorizontal(lipgloss.Center, ui.RenderSearchInput(), " ", status)
+	if queryErr != nil {
+		queryDisplay += "\n" + statusErrorStyle.UnsetMargins().Render("❌ "+queryErr.Error()) + " " + timestampStyle.Render("(showing last valid results)")
+	}
+	if ui.service.IsLoading() {
+		queryDisplay += "\n" + ui.renderProgress()
 	}
 
+	// Create help text
+	help := ui.renderHelp(ui.keys.SearchShortHelp())
+
 	var content string
 
-	if len(results) == 0 {
+	if totalCount == 0 {
 		noResults := statusStyle.Render("No commands found matching your search.")
 		content = header + "\n\n" + queryDisplay + "\n\n" + noResults
 	} else {
-		// Limit results to top 5
-		displayResults := results
-		if len(results) > 5 {
-			displayResults = results[:5]
-		}
-
 		// Create results count
-		totalCount := len(results)
-		displayCount := len(displayResults)
 		var countText string
 		if query == "" {
-			countText = fmt.Sprintf("Showing %d recent commands:", displayCount)
-		} else if totalCount > 5 {
-			countText = fmt.Sprintf("Found %d matching commands (showing top %d):", totalCount, displayCount)
+			countText = fmt.Sprintf("Showing %d commands", totalCount)
 		} else {
-			countText = fmt.Sprintf("Found %d matching commands:", displayCount)
+			countText = fmt.Sprintf("Found %d matching commands", totalCount)
 		}
+		countText += fmt.Sprintf(" · %d/%d", selectedIndex+1, totalCount)
 		count := statusStyle.Render(countText)
 
-		// Create results list with beautiful styling
-		var resultItems []string
-		for i, cmd := range displayResults {
-			var prefix string
-			// Only adjust selectedIndex if it's out of bounds
-			displaySelectedIndex := selectedIndex
-			if selectedIndex >= len(displayResults) {
-				displaySelectedIndex = len(displayResults) - 1
-			} else if selectedIndex < 0 {
-				displaySelectedIndex = 0
-			}
-
-			if i == displaySelectedIndex {
-				prefix = selectedItemStyle.Render("▶")
-			} else {
-				prefix = "  "
-			}
-
-			number := commandNumberStyle.Render(fmt.Sprintf("%d.", i+1))
-			command := commandTextStyle.Render(cmd.Command)
-			timestamp := timestampStyle.Render(cmd.When.Format("2006-01-02 15:04:05"))
-
-			resultLine := fmt.Sprintf("%s %s %s\n   %s", prefix, number, command, timestamp)
-			resultItems = append(resultItems, resultLine)
-		}
+		// Fill the rest of the window with the results
+		height := ui.listHeight(4, header, queryDisplay, count, help)
+		selected := result(max(min(selectedIndex, totalCount-1), 0))
+		resultsList := ui.renderListWithPreview(totalCount, selectedIndex, height, &selected.FishCommand, selected.Matches, layout, func(i int, selected bool) string {
+			result := result(i)
+			return ui.renderCommandRow(result.FishCommand, result.Matches, i, selected)
+		})
+		layout.place(lipgloss.Height(header) + lipgloss.Height(queryDisplay) + lipgloss.Height(count) + 3)
 
-		resultsList := strings.Join(resultItems, "\n\n")
 		content = header + "\n\n" + queryDisplay + "\n\n" + count + "\n\n" + resultsList
 	}
 
-	// Create help text
+	// Combine everything
+	fullContent := content + "\n\n" + help
+
+	return ui.renderContainer(fullContent)
+}
+
+// RenderInlineView renders the history or the search results in inline mode:
+// a line with the query, the position and info, then a line per command, in
+// no more lines than the height. There is no container, header or help.
+func (ui *FishHistoryUI) RenderInlineView(result func(i int) SearchResult, totalCount, selectedIndex int, info string, layout *listLayout) string {
+	prompt := searchPromptStyle.Render("> ") + ui.searchInput.View()
+
+	if !ui.service.IsHistoryLoaded() && len(ui.service.GetHistory()) == 0 {
+		return prompt + "\n" + timestampStyle.Render("Loading history…")
+	}
+	if totalCount == 0 {
+		return prompt + "\n" + timestampStyle.Render("No commands found")
+	}
+
+	info = strings.TrimSuffix(fmt.Sprintf("%d/%d · %s", selectedIndex+1, totalCount, info), " · ")
+	header := prompt + "  " + timestampStyle.Render(info)
+
+	width := ui.innerWidth()
+	line := lipgloss.NewStyle().MaxWidth(width)
+	marker := selectedItemStyle.Render("▶")
+	list := ui.renderList(totalCount, selectedIndex, width, max(ui.height-1, 1), layout, func(i int, selected bool) string {
+		prefix := strings.Repeat(" ", lipgloss.Width(marker))
+		if selected {
+			prefix = marker
+		}
+		result := result(i)
+		return line.Render(prefix + " " + highlightCommand(result.Command, result.Matches))
+	})
+	return line.Render(header) + "\n" + list
+}
+
+// SetInline switches between the inline views and the ones that fill the
+// window
+func (ui *FishHistoryUI) SetInline(inline bool) {
+	ui.inline = inline
+}
+
+// renderListWithPreview renders a list with the preview of its selected
+// command next to it or below it, or the list alone when the preview is
+// hidden or nothing is selected. matches are the positions of cmd matching
+// the query.
+func (ui *FishHistoryUI) renderListWithPreview(count, selected, height int, cmd *FishCommand, matches []int, layout *listLayout, row func(i int, selected bool) string) string {
+	width := ui.innerWidth()
+	where := ui.previewLayout()
+	if cmd == nil {
+		where = previewHidden
+	}
+
+	switch where {
+	case previewSide:
+		previewWidth := (width - previewGap) * 2 / 5
+		list := ui.renderList(count, selected, width-previewWidth-previewGap, height, layout, row)
+		preview := ui.renderPreview(*cmd, matches, previewWidth, height)
+		return lipgloss.JoinHorizontal(lipgloss.Top, list, strings.Repeat(" ", previewGap), preview)
+	case previewBelow:
+		previewHeight := height / 2
+		list := ui.renderList(count, selected, width, max(height-previewHeight-1, 1), layout, row)
+		return list + "\n\n" + ui.renderPreview(*cmd, matches, width, previewHeight)
+	}
+	return ui.renderList(count, selected, width, height, layout, row)
+}
+
+// previewLayout returns where the preview goes at the current width
+func (ui *FishHistoryUI) previewLayout() previewLayout {
+	wide := ui.innerWidth() >= previewSplitWidth
+	switch {
+	case wide && !ui.previewToggled:
+		return previewSide
+	case !wide && ui.previewToggled:
+		return previewBelow
+	}
+	return previewHidden
+}
+
+// TogglePreview shows or hides the preview of the selected command
+func (ui *FishHistoryUI) TogglePreview() {
+	ui.previewToggled = !ui.previewToggled
+}
+
+// renderPreview renders the details of a command in a box of width by
+// height: the whole command wrapped, when it ran, where it came from, the
+// paths it touched and the commands run around it
+func (ui *FishHistoryUI) renderPreview(cmd FishCommand, matches []int, width, height int) string {
+	inner := max(width-previewStyle.GetHorizontalFrameSize(), 1)
+	line := lipgloss.NewStyle().MaxWidth(inner)
+
+	sections := []string{lipgloss.NewStyle().Width(inner).Render(highlightCommandLines(cmd.Command, matches))}
+
+	runs := cmd.RunTimes()
+	details := previewLabelStyle.Render("Last run ") + timestampStyle.Render(cmd.FormatWhen())
+	if len(runs) > 1 {
+		details += "\n" + previewLabelStyle.Render("First run ") + timestampStyle.Render(formatRunTime(cmd.FirstRun()))
+		details += "\n" + previewLabelStyle.Render("Runs ") + timestampStyle.Render(fmt.Sprint(len(runs)))
+		for _, when := range runs[:min(len(runs), previewRuns)] {
+			details += "\n  " + timestampStyle.Render(formatRunTime(when))
+		}
+		if len(runs) > previewRuns {
+			details += "\n  " + timestampStyle.Render(fmt.Sprintf("… and %d more", len(runs)-previewRuns))
+		}
+	}
+	if len(cmd.Origins) > 0 {
+		details += "\n" + previewLabelStyle.Render("From ") + originStyle.Render(strings.Join(cmd.Origins, ", "))
+	}
+	sections = append(sections, details)
+
+	if len(cmd.Paths) > 0 {
+		paths := previewLabelStyle.Render("Paths")
+		for _, path := range cmd.Paths {
+			paths += "\n" + pathStyle.Width(inner).Render("📁 "+path)
+		}
+		sections = append(sections, paths)
+	}
+
+	before, after := ui.service.GetNeighbors(cmd, previewNeighbors)
+	for _, neighbors := range []struct {
+		label    string
+		commands []FishCommand
+	}{{"Run before", before}, {"Run after", after}} {
+		if len(neighbors.commands) == 0 {
+			continue
+		}
+		list := previewLabelStyle.Render(neighbors.label)
+		for _, neighbor := range neighbors.commands {
+			list += "\n" + line.Render(highlightCommand(neighbor.Command, nil))
+		}
+		sections = append(sections, list)
+	}
+
+	// Cut what does not fit, leaving a line to say so
+	innerHeight := max(height-previewStyle.GetVerticalFrameSize(), 1)
+	lines := strings.Split(strings.Join(sections, "\n\n"), "\n")
+	if len(lines) > innerHeight {
+		lines = append(lines[:innerHeight-1], timestampStyle.Render("…"))
+	}
+	return previewStyle.Width(inner + previewStyle.GetHorizontalPadding()).Height(innerHeight).Render(strings.Join(lines, "\n"))
+}
+
+// renderList renders as many rows of a list as fit in width by height,
+// scrolled from the last layout so that the selected row is on screen. row
+// renders the i-th of count rows; only the rows on screen are rendered.
+// Where the rows went is recorded in layout unless it is nil.
+func (ui *FishHistoryUI) renderList(count, selected, width, height int, layout *listLayout, row func(i int, selected bool) string) string {
+	if layout != nil {
+		layout.width, layout.height = width, height
+		layout.pageSize = 1
+	}
+	if count == 0 {
+		return ""
+	}
+	selected = max(min(selected, count-1), 0)
+
+	rendered := make(map[int]string)
+	render := func(i int) string {
+		if text, ok := rendered[i]; ok {
+			return text
+		}
+		text := row(i, i == selected)
+		rendered[i] = text
+		return text
+	}
+	// Rows are a blank line apart, except inline
+	gap := 1
+	if ui.inline {
+		gap = 0
+	}
+	// linesFrom counts the lines of the rows from first to last, with the
+	// gaps between rows
+	linesFrom := func(first, last int) int {
+		lines := 0
+		for i := first; i <= last; i++ {
+			lines += lipgloss.Height(render(i)) + gap
+		}
+		return lines - gap
+	}
+
+	// Every row takes a line at least, which bounds how far to scroll
+	offset := max(min(ui.layout.offset, selected), selected-height+1, 0)
+	for offset < selected && linesFrom(offset, selected) > height {
+		offset++
+	}
+
+	var rows []string
+	var spans []rowSpan
+	lines, visible := 0, 0
+	for i := offset; i < count && lines < height; i++ {
+		text := render(i)
+		spans = append(spans, rowSpan{index: i, top: lines, bottom: min(lines+lipgloss.Height(text), height)})
+		lines += lipgloss.Height(text) + gap
+		if lines-gap <= height {
+			visible++
+		}
+		rows = append(rows, text)
+	}
+	if layout != nil {
+		layout.offset = offset
+		layout.pageSize = max(visible, 1)
+		layout.rows = spans
+	}
+
+	// The viewport clips a last row that only partly fits
+	vp := viewport.New(width, height)
+	vp.SetContent(strings.Join(rows, strings.Repeat("\n", gap+1)))
+	return vp.View()
+}
+
+// SetLayout keeps the layout of the view, worked out for the next frame.
+// viewLines is the height of the whole view, since the terminal only shows
+// the bottom of a view taller than itself.
+func (ui *FishHistoryUI) SetLayout(layout listLayout, viewLines int) {
+	layout.clippedLines = max(viewLines-ui.height, 0)
+	ui.layout = layout
+}
+
+// RowAt returns the row of the list shown at the screen cell x, y, if any.
+// The blank lines between rows and the preview are not part of any row, and
+// inline views, which start on an unknown line of the screen, have none.
+func (ui *FishHistoryUI) RowAt(x, y int) (int, bool) {
+	if ui.inline {
+		return 0, false
+	}
+	layout := ui.layout
+	x, y = x-layout.x, y+layout.clippedLines-layout.y
+	if x < 0 || x >= layout.width || y < 0 || y >= layout.height {
+		return 0, false
+	}
+	for _, span := range layout.rows {
+		if y >= span.top && y < span.bottom {
+			return span.index, true
+		}
+	}
+	return 0, false
+}
+
+// listHeight returns how many lines are left for a list once the other parts
+// of a view, the blank lines between them and the status line are laid out
+func (ui *FishHistoryUI) listHeight(blankLines int, parts ...string) int {
+	used := containerStyle.GetVerticalFrameSize() + statusLineHeight + blankLines
+	for _, part := range parts {
+		used += lipgloss.Height(part)
+	}
+	return max(ui.height-used, minListHeight)
+}
+
+// innerWidth returns the width available inside the container, or the whole
+// width inline
+func (ui *FishHistoryUI) innerWidth() int {
+	if ui.inline {
+		return max(ui.width, minListWidth)
+	}
+	return max(ui.width-containerStyle.GetHorizontalFrameSize(), minListWidth)
+}
+
+// renderContainer wraps a view in the container, as wide as the window
+func (ui *FishHistoryUI) renderContainer(content string) string {
+	return containerStyle.Width(ui.innerWidth() + containerStyle.GetHorizontalPadding()).Render(content)
+}
+
+// PageSize returns how many rows of the list are on screen
+func (ui *FishHistoryUI) PageSize() int {
+	return ui.layout.pageSize
+}
+
+// RenderErrorView renders the screen shown when the history could not be
+// loaded: what went wrong, which file was tried and how to recover
+func (ui *FishHistoryUI) RenderErrorView(err error, pathInput textinput.Model, editingPath bool) string {
+	source := ui.service.GetSource()
+	header := headerStyle.Render("⚠️  Could not load " + source.Name() + " history")
+
+	path := source.Path()
+	if path == "" {
+		path = "(none)"
+	}
+	details := statusErrorStyle.UnsetMargins().Render(err.Error()) + "\n\n" +
+		searchPromptStyle.Render("Path: ") + commandTextStyle.Render(path)
+
+	var hint string
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		hint = "The file does not exist. Has " + source.Name() + " written any history yet, or is it kept somewhere else?"
+	case errors.Is(err, os.ErrPermission):
+		hint = "The file is not readable. Check its permissions with `ls -l " + source.Path() + "`."
+	default:
+		hint = "Check that the file is a " + source.Name() + " history file."
+	}
+	hint = statusStyle.Render(hint + "\nYou can point -history, $" + historyPathEnv + " or the config file at another file.")
+
 	var help string
-	if query == "" {
-		help = helpStyle.Render("Press " + keyStyle.Render("Ctrl+C") + " to quit, " + keyStyle.Render("↑/↓") + " or " + keyStyle.Render("Ctrl+J/K") + " to navigate, " + keyStyle.Render("Enter") + " to copy, " + keyStyle.Render("type") + " to search")
+	if editingPath {
+		details += "\n\n" + searchPromptStyle.Render("New path: ") + searchBoxStyle.UnsetMargins().Render(pathInput.View())
+		help = ui.renderHelp([]key.Binding{withDesc(ui.keys.Select, "load this file"), withDesc(ui.keys.Back, "cancel")})
 	} else {
-		help = helpStyle.Render("Press " + keyStyle.Render("Ctrl+C") + " to quit, " + keyStyle.Render("ESC") + " to exit search, " + keyStyle.Render("↑/↓") + " or " + keyStyle.Render("Ctrl+J/K") + " to navigate, " + keyStyle.Render("Enter") + " to copy")
+		help = ui.renderHelp(ui.keys.ErrorBindings())
 	}
 
-	// Combine everything
-	fullContent := content + "\n\n" + help
+	return containerStyle.Render(header + "\n\n" + details + "\n" + hint + "\n" + help)
+}
+
+// highlightCommand renders a command on a single line like DisplayCommand,
+// colored by shell syntax, with the runes at the matched positions painted
+// with matchStyle on top
+func highlightCommand(command string, matches []int) string {
+	return renderShellCommand(command, matches, " ↵ ")
+}
+
+// highlightCommandLines renders a command like highlightCommand but keeps
+// the line breaks of multi-line commands
+func highlightCommandLines(command string, matches []int) string {
+	return renderShellCommand(command, matches, "\n")
+}
+
+// renderShellCommand renders a command colored by shell syntax, replacing
+// line breaks with newline. Terminals without colors get the plain text,
+// without tokenizing it.
+func renderShellCommand(command string, matches []int, newline string) string {
+	if lipgloss.ColorProfile() == termenv.Ascii {
+		return strings.ReplaceAll(command, "\n", newline)
+	}
+
+	kinds := tokenizeShell(command)
+	var b, run strings.Builder
+	runKind, runMatched := TokenText, false
+	flush := func() {
+		if run.Len() == 0 {
+			return
+		}
+		style := syntaxStyles[runKind]
+		if runMatched {
+			style = matchStyle.Inherit(style)
+		}
+		b.WriteString(style.Render(run.String()))
+		run.Reset()
+	}
 
-	return containerStyle.Render(fullContent)
+	next := 0
+	for i, r := range []rune(command) {
+		matched := next < len(matches) && matches[next] == i
+		if matched {
+			next++
+		}
+		if matched != runMatched || kinds[i] != runKind {
+			flush()
+			runKind, runMatched = kinds[i], matched
+		}
+		if r == '\n' {
+			// Styles never span a line break
+			flush()
+			b.WriteString(newline)
+		} else {
+			run.WriteRune(r)
+		}
+	}
+	flush()
+	return b.String()
+}
+
+// historyTitle returns the header naming the active history source
+func (ui *FishHistoryUI) historyTitle() string {
+	name := ui.service.GetSource().Name()
+	if name == ShellFish {
+		return "🐟 Fish History"
+	}
+	return "🐚 " + strings.ToUpper(name[:1]) + name[1:] + " History"
 }
 
-// LoadHistoryMessage creates a message for loading fish history
-func (ui *FishHistoryUI) LoadHistoryMessage() tea.Msg {
-	commands, err := ui.service.LoadHistory()
-	if err != nil {
-		return ui.service.CreateErrorMessage(err)
+// rankLabel names the order the history is shown in
+func (ui *FishHistoryUI) rankLabel() string {
+	label := "· by " + ui.service.GetRankMode().String()
+	if ui.service.IsGrouped() {
+		label += ", grouped"
 	}
-	return ui.service.CreateHistoryMessage(commands)
+	return label
+}
+
+// RenderSessionView renders the picker listing the fish history sessions
+func (ui *FishHistoryUI) RenderSessionView(sessions []HistorySession, selectedIndex int) string {
+	header := headerStyle.Render("📂 History Sessions")
+	subtitle := statusStyle.Render(fmt.Sprintf("%d history files found", len(sessions)))
+
+	var items []string
+	for i, session := range sessions {
+		var prefix string
+		if i == selectedIndex {
+			prefix = selectedItemStyle.Render("▶")
+		} else {
+			prefix = "  "
+		}
+
+		name := commandTextStyle.Render(session.Name)
+		if session.Path == ui.service.GetSource().Path() {
+			name += " " + keyStyle.Render("(current)")
+		}
+		details := timestampStyle.Render(fmt.Sprintf("%s · %d KiB · %s", session.Path, session.Size/1024, session.ModTime.Format("2006-01-02 15:04:05")))
+
+		items = append(items, fmt.Sprintf("%s %s\n   %s", prefix, name, details))
+	}
+
+	help := ui.renderHelp(ui.keys.SessionBindings())
+
+	content := header + "\n" + subtitle + "\n\n" + strings.Join(items, "\n\n") + "\n\n" + help
+	return containerStyle.Render(content)
+}
+
+// renderCommandRow renders one command of a list: the numbered command with
+// the characters matching the query highlighted, its timestamp and origin,
+// and the paths it touched if fish recorded any
+func (ui *FishHistoryUI) renderCommandRow(cmd FishCommand, matches []int, index int, selected bool) string {
+	var prefix string
+	if selected {
+		prefix = selectedItemStyle.Render("▶")
+	} else {
+		prefix = "  "
+	}
+
+	number := commandNumberStyle.Render(fmt.Sprintf("%d.", index+1))
+	command := highlightCommand(cmd.Command, matches)
+
+	meta := timestampStyle.Render(cmd.FormatWhen())
+	runs := len(cmd.RunTimes())
+	if runs > 1 {
+		meta = timestampStyle.Render(fmt.Sprintf("last %s · first %s · %d runs", cmd.FormatWhen(), formatRunTime(cmd.FirstRun()), runs))
+	}
+	if len(cmd.Origins) > 0 {
+		meta += timestampStyle.Render(" · ") + originStyle.Render(strings.Join(cmd.Origins, ", "))
+	}
+
+	row := fmt.Sprintf("%s %s %s\n   %s", prefix, number, command, meta)
+	if runs > 1 && ui.expanded[cmd.Command] {
+		row += "\n" + renderRunTimes(cmd.RunTimes())
+	}
+	if len(cmd.Paths) > 0 {
+		row += "\n   " + pathStyle.Render("📁 "+strings.Join(cmd.Paths, ", "))
+	}
+	return row
+}
+
+// renderRunTimes lists every run of an expanded entry, a few per line
+func renderRunTimes(runs []time.Time) string {
+	const perLine = 3
+
+	var lines []string
+	for start := 0; start < len(runs); start += perLine {
+		var times []string
+		for _, when := range runs[start:min(start+perLine, len(runs))] {
+			times = append(times, formatRunTime(when))
+		}
+		lines = append(lines, "     "+timestampStyle.Render(strings.Join(times, "  ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ToggleExpanded shows or hides every run of a command
+func (ui *FishHistoryUI) ToggleExpanded(cmd FishCommand) {
+	ui.expanded[cmd.Command] = !ui.expanded[cmd.Command]
+}
+
+// StartLoading starts streaming the history in the background and returns
+// the command that waits for its first batch
+func (ui *FishHistoryUI) StartLoading() tea.Cmd {
+	return ui.service.StartLoad().Next
+}
+
+// renderLoading renders the screen shown before any entry has been read
+func (ui *FishHistoryUI) renderLoading() string {
+	loading := loadingStyle.Render(fmt.Sprintf("🔄 Loading %s history...", ui.service.GetSource().Name()))
+	help := ui.renderHelp([]key.Binding{ui.keys.Quit})
+	return containerStyle.Render(loading + "\n" + ui.renderProgress() + "\n\n" + help)
+}
+
+// renderProgress renders a progress bar of the bytes read so far
+func (ui *FishHistoryUI) renderProgress() string {
+	const barWidth = 30
+
+	read, total := ui.service.GetLoadProgress()
+	fraction := 0.0
+	if total > 0 {
+		fraction = min(float64(read)/float64(total), 1)
+	}
+	filled := int(fraction * barWidth)
+
+	bar := progressFilledStyle.Render(strings.Repeat("█", filled)) + progressEmptyStyle.Render(strings.Repeat("░", barWidth-filled))
+	details := statusStyle.UnsetMargins().Render(fmt.Sprintf("%3.0f%% · %s of %s · %d commands", fraction*100, formatBytes(read), formatBytes(total), len(ui.service.GetHistory())))
+	return bar + " " + details
+}
+
+// formatBytes formats a byte count with a binary unit
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
 
 // IsHistoryLoaded checks if history is loaded
@@ -321,6 +940,8 @@ func (ui *FishHistoryUI) SetSize(width, height int) {
 	ui.viewport.Height = height - 4 // Leave space for header and help
 	ui.list.SetWidth(width)
 	ui.list.SetHeight(height - 4)
+	// Leave room for the prompt, the box and the match mode after it
+	ui.searchInput.Width = max(ui.innerWidth()-searchInputReserve, minSearchInputWidth)
 }
 
 // GetSearchInput returns the search input model
@@ -333,11 +954,41 @@ func (ui *FishHistoryUI) UpdateSearchInput(input textinput.Model) {
 	ui.searchInput = input
 }
 
+// ClearSearchInput empties the search input
+func (ui *FishHistoryUI) ClearSearchInput() {
+	ui.searchInput.Reset()
+}
+
 // RenderSearchInput renders just the search input with styling
 func (ui *FishHistoryUI) RenderSearchInput() string {
-	searchBox := searchBoxStyle.Render(ui.searchInput.View())
+	searchBox := searchBoxStyle.UnsetMargins().Render(ui.searchInput.View())
 	prompt := searchPromptStyle.Render("Search: ")
-	return prompt + searchBox
+	return lipgloss.JoinHorizontal(lipgloss.Center, prompt, searchBox)
+}
+
+// renderHelp renders the footer listing bindings, cut to the window width
+func (ui *FishHistoryUI) renderHelp(bindings []key.Binding) string {
+	h := ui.help
+	h.Width = ui.innerWidth()
+	return helpStyle.Render(h.ShortHelpView(bindings))
+}
+
+// RenderHelpView renders the overlay listing every binding and the query
+// syntax
+func (ui *FishHistoryUI) RenderHelpView() string {
+	header := headerStyle.Render("⌨️  Keys")
+	h := ui.help
+	h.Width = ui.innerWidth()
+	bindings := h.FullHelpView(ui.keys.FullHelp())
+
+	syntax := searchPromptStyle.Render("Query syntax") + "\n" + helpStyle.UnsetMargins().Width(ui.innerWidth()).Render(
+		keyStyle.Render("cmd:")+"/"+keyStyle.Render("path:")+"/"+keyStyle.Render("origin:")+" to match one field, "+
+			keyStyle.Render("after:")+"/"+keyStyle.Render("before:")+" to filter by date, "+
+			keyStyle.Render("-word")+" to exclude, "+keyStyle.Render("\\-m")+" for a flag, "+keyStyle.Render("\"a phrase\"")+" to match exactly, "+
+			keyStyle.Render("a OR b")+" for either, "+keyStyle.Render("re:")+" for a regex")
+
+	footer := helpStyle.Render("Press any key to close")
+	return ui.renderContainer(header + "\n" + bindings + "\n\n" + syntax + "\n" + footer)
 }
 
 // RenderStatusMessage renders a status message with appropriate styling
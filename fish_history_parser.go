@@ -0,0 +1,267 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxHistoryLineSize bounds a single line of a history file; fish stores a
+// whole multi-line command on one escaped line, so the default scanner limit
+// of 64KiB is too small for pasted scripts.
+const maxHistoryLineSize = 16 * 1024 * 1024
+
+// ParseDiagnostic describes a problem found while decoding a history file
+type ParseDiagnostic struct {
+	Line   int
+	Reason string
+}
+
+// String formats the diagnostic for logs and the UI
+func (d ParseDiagnostic) String() string {
+	return fmt.Sprintf("line %d: %s", d.Line, d.Reason)
+}
+
+// fishEntry is an entry that is still being decoded
+type fishEntry struct {
+	cmd     FishCommand
+	line    int
+	indent  int
+	hasCmd  bool
+	hasWhen bool
+}
+
+// FishHistoryDecoder decodes fish's pseudo-YAML history format.
+//
+// Fish writes one entry per command as a list item holding a "cmd" key,
+// followed by indented "when" (a unix timestamp) and optional "paths" keys.
+//
+// Commands and paths are escaped so that a backslash is written as `\\` and a
+// newline as `\n`. Values are otherwise taken as written, quotes included,
+// as fish itself reads them. Unknown keys are skipped and malformed entries
+// are reported as diagnostics instead of being dropped silently.
+type FishHistoryDecoder struct {
+	scanner     *bufio.Scanner
+	line        int
+	current     *fishEntry
+	inPaths     bool
+	inUnknown   bool
+	diagnostics []ParseDiagnostic
+	err         error
+	done        bool
+}
+
+// NewFishHistoryDecoder creates a decoder reading from r
+func NewFishHistoryDecoder(r io.Reader) *FishHistoryDecoder {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryLineSize)
+	return &FishHistoryDecoder{scanner: scanner}
+}
+
+// Next decodes the next complete entry. It returns false once the input is
+// exhausted or a read error occurred; check Err to tell the two apart.
+func (d *FishHistoryDecoder) Next() (FishCommand, bool) {
+	for !d.done {
+		if !d.scanner.Scan() {
+			d.done = true
+			d.err = d.scanner.Err()
+			if cmd, ok := d.finish(); ok {
+				return cmd, true
+			}
+			break
+		}
+		d.line++
+		if cmd, ok := d.decodeLine(d.scanner.Text()); ok {
+			return cmd, true
+		}
+	}
+	return FishCommand{}, false
+}
+
+// DecodeAll decodes every remaining entry
+func (d *FishHistoryDecoder) DecodeAll() ([]FishCommand, error) {
+	var commands []FishCommand
+	for {
+		cmd, ok := d.Next()
+		if !ok {
+			break
+		}
+		commands = append(commands, cmd)
+	}
+	return commands, d.err
+}
+
+// Diagnostics returns the problems found so far
+func (d *FishHistoryDecoder) Diagnostics() []ParseDiagnostic {
+	return d.diagnostics
+}
+
+// Err returns the read error that stopped decoding, if any
+func (d *FishHistoryDecoder) Err() error {
+	return d.err
+}
+
+// decodeLine consumes a single line and returns an entry when the line
+// completes the previous one
+func (d *FishHistoryDecoder) decodeLine(raw string) (FishCommand, bool) {
+	raw = strings.TrimRight(raw, "\r")
+	content := strings.TrimLeft(raw, " \t")
+	if content == "" {
+		return FishCommand{}, false
+	}
+	indent := len(raw) - len(content)
+
+	// A list item at the entry's own indentation starts a new entry
+	if isListItem(content) && (d.current == nil || indent <= d.current.indent) {
+		finished, ok := d.finish()
+		d.startEntry(strings.TrimLeft(content[1:], " \t"), indent)
+		return finished, ok
+	}
+
+	if d.current == nil {
+		d.addDiagnostic(d.line, "unexpected content outside of an entry")
+		return FishCommand{}, false
+	}
+
+	if isListItem(content) {
+		switch {
+		case d.inPaths:
+			value := strings.TrimLeft(content[1:], " \t")
+			d.current.cmd.Paths = append(d.current.cmd.Paths, unescapeFish(value))
+		case d.inUnknown:
+			// Items of a key we do not know about
+		default:
+			d.addDiagnostic(d.line, "list item without a list key")
+		}
+		return FishCommand{}, false
+	}
+
+	key, value, ok := splitFishKey(content)
+	if !ok {
+		if !d.inUnknown {
+			d.addDiagnostic(d.line, fmt.Sprintf("expected key: value, got %q", content))
+		}
+		return FishCommand{}, false
+	}
+	d.setKey(key, value)
+	return FishCommand{}, false
+}
+
+// startEntry begins a new entry from the text following its list dash
+func (d *FishHistoryDecoder) startEntry(item string, indent int) {
+	d.current = &fishEntry{line: d.line, indent: indent}
+	d.inPaths = false
+	d.inUnknown = false
+
+	key, value, ok := splitFishKey(item)
+	if !ok {
+		d.addDiagnostic(d.line, fmt.Sprintf("expected key: value, got %q", item))
+		return
+	}
+	d.setKey(key, value)
+}
+
+// setKey applies a key of the current entry
+func (d *FishHistoryDecoder) setKey(key, value string) {
+	d.inPaths = false
+	d.inUnknown = false
+
+	switch key {
+	case "cmd":
+		if d.current.hasCmd {
+			d.addDiagnostic(d.line, "duplicate cmd key")
+		}
+		d.current.cmd.Command = unescapeFish(value)
+		d.current.hasCmd = true
+	case "when":
+		timestamp, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+		if err != nil {
+			d.addDiagnostic(d.line, fmt.Sprintf("invalid timestamp %q", value))
+			return
+		}
+		d.current.cmd.When = time.Unix(timestamp, 0)
+		d.current.hasWhen = true
+	case "paths":
+		d.inPaths = true
+	default:
+		// Unknown keys are skipped together with any nested list
+		d.inUnknown = true
+	}
+}
+
+// finish completes the current entry, reporting it if it is unusable
+func (d *FishHistoryDecoder) finish() (FishCommand, bool) {
+	entry := d.current
+	d.current = nil
+	d.inPaths = false
+	d.inUnknown = false
+	if entry == nil {
+		return FishCommand{}, false
+	}
+
+	switch {
+	case !entry.hasCmd:
+		d.addDiagnostic(entry.line, "entry has no cmd")
+	case entry.cmd.Command == "":
+		d.addDiagnostic(entry.line, "entry has an empty cmd")
+	case !entry.hasWhen:
+		d.addDiagnostic(entry.line, "entry has no valid when")
+	default:
+		return entry.cmd, true
+	}
+	return FishCommand{}, false
+}
+
+// addDiagnostic records a problem at the given line
+func (d *FishHistoryDecoder) addDiagnostic(line int, reason string) {
+	d.diagnostics = append(d.diagnostics, ParseDiagnostic{Line: line, Reason: reason})
+}
+
+// isListItem reports whether a trimmed line is a YAML list item
+func isListItem(content string) bool {
+	return content == "-" || strings.HasPrefix(content, "- ") || strings.HasPrefix(content, "-\t")
+}
+
+// splitFishKey splits "key: value" into its parts
+func splitFishKey(content string) (string, string, bool) {
+	key, value, found := strings.Cut(content, ":")
+	if !found {
+		return "", "", false
+	}
+	key = strings.TrimSpace(key)
+	if key == "" || strings.ContainsAny(key, " \t") {
+		return "", "", false
+	}
+	return key, strings.TrimLeft(value, " \t"), true
+}
+
+// unescapeFish reverses fish's history escaping: `\\` becomes a backslash and
+// `\n` a newline. Any other backslash sequence is kept as written, matching
+// fish's own reader. Fish never quotes values, so quotes are kept too.
+func unescapeFish(value string) string {
+	if !strings.Contains(value, `\`) {
+		return value
+	}
+
+	var b strings.Builder
+	b.Grow(len(value))
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' && i+1 < len(value) {
+			switch value[i+1] {
+			case '\\':
+				b.WriteByte('\\')
+				i++
+				continue
+			case 'n':
+				b.WriteByte('\n')
+				i++
+				continue
+			}
+		}
+		b.WriteByte(value[i])
+	}
+	return b.String()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFishHistoryDecoderCommands(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  string
+		want string
+	}{
+		{name: "plain", cmd: "git status", want: "git status"},
+		{name: "double quoted", cmd: `"$EDITOR"`, want: `"$EDITOR"`},
+		{name: "single quoted", cmd: `'ls'`, want: `'ls'`},
+		{name: "quoted with backslash", cmd: `"C:\\temp\tx"`, want: `"C:\temp\tx"`},
+		{name: "quoted words", cmd: `echo "a" 'b'`, want: `echo "a" 'b'`},
+		{name: "escaped newline", cmd: `begin\n  echo hi\nend`, want: "begin\n  echo hi\nend"},
+		{name: "escaped backslash", cmd: `printf '%s\\n' x`, want: `printf '%s\n' x`},
+		{name: "other escape kept", cmd: `echo \t`, want: `echo \t`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			input := "- cmd: " + tt.cmd + "\n  when: 1700000000\n"
+			commands, err := NewFishHistoryDecoder(strings.NewReader(input)).DecodeAll()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(commands) != 1 {
+				t.Fatalf("decoded %d commands, want 1", len(commands))
+			}
+			if commands[0].Command != tt.want {
+				t.Errorf("Command = %q, want %q", commands[0].Command, tt.want)
+			}
+		})
+	}
+}
+
+func TestFishHistoryDecoderEntries(t *testing.T) {
+	input := `- cmd: vim "notes.md"
+  when: 1700000000
+  paths:
+    - "notes.md"
+    - dir\\file
+- cmd: ls
+  when: 1700000001
+  unknown:
+    - skipped
+- when: 1700000002
+- cmd: no time
+`
+	decoder := NewFishHistoryDecoder(strings.NewReader(input))
+	commands, err := decoder.DecodeAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []FishCommand{
+		{Command: `vim "notes.md"`, When: time.Unix(1700000000, 0), Paths: []string{`"notes.md"`, `dir\file`}},
+		{Command: "ls", When: time.Unix(1700000001, 0)},
+	}
+	if len(commands) != len(want) {
+		t.Fatalf("decoded %d commands, want %d", len(commands), len(want))
+	}
+	for i := range want {
+		got := commands[i]
+		if got.Command != want[i].Command || !got.When.Equal(want[i].When) || !slices.Equal(got.Paths, want[i].Paths) {
+			t.Errorf("command %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+
+	var reasons []string
+	for _, diagnostic := range decoder.Diagnostics() {
+		reasons = append(reasons, diagnostic.String())
+	}
+	if len(reasons) != 2 {
+		t.Errorf("diagnostics = %q, want one for the entry without cmd and one without when", reasons)
+	}
+}
@@ -1,108 +1,444 @@
 package main
 
 import (
-	"bufio"
 	"fmt"
 	"os"
-	"path/filepath"
-	"sort"
-	"strconv"
+	"slices"
 	"strings"
 	"time"
 )
 
-// FishCommand represents a single command from fish history
+// FishCommand represents a single command from a shell's history
 type FishCommand struct {
 	Command string
 	When    time.Time
+	// Paths lists the files fish recorded as arguments of the command
+	Paths []string
+	// Origins names the sources the command was read from
+	Origins []string
+	// Timestamps holds every run, newest first, once identical commands from
+	// several sources have been merged
+	Timestamps []time.Time
 }
 
-// fishHistoryMsg represents a message containing fish history data
+// RunTimes returns every time the command ran, newest first
+func (c FishCommand) RunTimes() []time.Time {
+	if len(c.Timestamps) > 0 {
+		return c.Timestamps
+	}
+	return []time.Time{c.When}
+}
+
+// FirstRun returns the earliest time the command ran
+func (c FishCommand) FirstRun() time.Time {
+	runs := c.RunTimes()
+	return runs[len(runs)-1]
+}
+
+// DisplayCommand returns the command on a single line, marking line breaks of
+// multi-line commands so list rows keep their shape
+func (c FishCommand) DisplayCommand() string {
+	return strings.ReplaceAll(c.Command, "\n", " ↵ ")
+}
+
+// FormatWhen formats the command's timestamp for display. Shells that were
+// not told to record times leave it unset.
+func (c FishCommand) FormatWhen() string {
+	return formatRunTime(c.When)
+}
+
+// formatRunTime formats the time of a run for display
+func formatRunTime(when time.Time) string {
+	if when.IsZero() {
+		return "unknown time"
+	}
+	return when.Format("2006-01-02 15:04:05")
+}
+
+// fishHistoryMsg reports that a history load has finished
 type fishHistoryMsg struct {
-	commands []FishCommand
-	err      error
+	loader      *HistoryLoader
+	diagnostics []ParseDiagnostic
+	err         error
+	// followInfo and followOffset describe how much of the live history file
+	// was read, so a watcher can pick up from there
+	followInfo   os.FileInfo
+	followOffset int64
 }
 
-// FishHistoryService handles all fish history operations
+// FishHistoryService handles all history operations for the active source
 type FishHistoryService struct {
 	logger        *LoggerService
+	source        HistorySource
+	archives      []HistorySource
 	history       []FishCommand
 	historyLoaded bool
+	loadErr       error
+	diagnostics   []ParseDiagnostic
+	// Streaming load state
+	loader     *HistoryLoader
+	primary    []FishCommand
+	archived   []FishCommand
+	merger     *timelineMerger
+	bytesRead  int64
+	totalBytes int64
+	// history is the tail of historyBuf from historyStart, so newer commands
+	// are prepended without moving the older ones. unsorted is set when a
+	// batch arrived out of order and the history awaits a sort.
+	historyBuf   []FishCommand
+	historyStart int
+	unsorted     bool
+	// Live-follow state
+	watcher *HistoryWatcher
+	// Ranking state: ranked is the history as listed, grouped when asked to
+	// or when rankMode needs it. groups is kept up to date as commands are
+	// added and is nil when not grouping; rankedGroups is how many groups
+	// there were when they were last ranked.
+	rankMode            RankMode
+	grouped             bool
+	normalizeWhitespace bool
+	ranked              []FishCommand
+	groups              *commandGroups
+	rankedGroups        int
 }
 
-// NewFishHistoryService creates a new fish history service
-func NewFishHistoryService(logger *LoggerService) *FishHistoryService {
+// NewFishHistoryService creates a new history service reading from source.
+// Entries of the archives are merged into the same timeline.
+func NewFishHistoryService(logger *LoggerService, source HistorySource, archives []HistorySource) *FishHistoryService {
 	return &FishHistoryService{
 		logger:        logger,
+		source:        source,
+		archives:      archives,
 		history:       []FishCommand{},
 		historyLoaded: false,
 	}
 }
 
-// LoadHistory loads and parses the fish history file
-func (s *FishHistoryService) LoadHistory() ([]FishCommand, error) {
-	homeDir, err := os.UserHomeDir()
-	if err != nil {
-		return nil, fmt.Errorf("failed to get home directory: %w", err)
+// StartLoad begins streaming the active source and the archives in the
+// background, abandoning any load still in progress. The returned loader
+// delivers historyBatchMsg values followed by a final fishHistoryMsg, which
+// are handed back to ApplyBatch and FinishLoad.
+func (s *FishHistoryService) StartLoad() *HistoryLoader {
+	s.reset()
+
+	sources := append([]HistorySource{s.source}, s.archives...)
+	if len(s.archives) > 0 {
+		s.merger = newTimelineMerger()
 	}
+	s.loader = newHistoryLoader(s.logger, sources)
+	s.logger.Infof("Loading %s history from %s with %d archives", s.source.Name(), s.source.Path(), len(s.archives))
+	return s.loader
+}
 
-	historyPath := filepath.Join(homeDir, ".local", "share", "fish", "fish_history")
-	file, err := os.Open(historyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open fish history: %w", err)
+// ApplyBatch adds a batch of streamed entries to the history. It returns
+// false for batches of a load that has since been replaced.
+func (s *FishHistoryService) ApplyBatch(msg historyBatchMsg) bool {
+	if msg.loader != s.loader {
+		return false
 	}
-	defer file.Close()
+	s.bytesRead = msg.bytesRead
+	s.totalBytes = msg.totalBytes
+	s.appendCommands(msg.commands, msg.archived)
+	return true
+}
 
-	var commands []FishCommand
-	scanner := bufio.NewScanner(file)
-	var currentCmd FishCommand
-	var inPaths bool
+// FinishLoad completes a load. It returns false for a load that has since
+// been replaced.
+func (s *FishHistoryService) FinishLoad(msg fishHistoryMsg) bool {
+	if msg.loader != s.loader {
+		return false
+	}
+	s.loader = nil
+	s.diagnostics = msg.diagnostics
+	if msg.err != nil {
+		s.loadErr = msg.err
+		return true
+	}
+	s.historyLoaded = true
+	s.bytesRead = s.totalBytes
+	if s.unsorted {
+		s.rebuildHistory()
+	} else if s.groups != nil {
+		s.rankGroups()
+	}
+	if msg.followInfo != nil {
+		s.watcher = newHistoryWatcher(s.source, msg.followInfo, msg.followOffset)
+	}
+	s.logger.Infof("Loaded %d history commands", len(s.history))
+	return true
+}
 
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
+// GetWatcher returns the watcher following the live history file once it has
+// been loaded, or nil
+func (s *FishHistoryService) GetWatcher() *HistoryWatcher {
+	return s.watcher
+}
 
-		if strings.HasPrefix(line, "- cmd: ") {
-			if currentCmd.Command != "" && !currentCmd.When.IsZero() {
-				commands = append(commands, currentCmd)
+// ApplyWatch applies the changes a watcher found in the live history file.
+// Appended entries are added to the history while a rewritten file replaces
+// everything read from it. It returns false when nothing changed or the
+// watcher has since been replaced.
+func (s *FishHistoryService) ApplyWatch(msg historyWatchMsg) bool {
+	if msg.watcher != s.watcher || msg.err != nil {
+		return false
+	}
+	for _, diagnostic := range msg.diagnostics {
+		s.logger.Warnf("%s history parse problem in new entries at %s", s.source.Name(), diagnostic)
+	}
+
+	if msg.rewritten {
+		s.logger.Infof("History file %s was rewritten, replacing %d commands with %d", s.source.Path(), len(s.primary), len(msg.commands))
+		s.diagnostics = msg.diagnostics
+		s.primary = append([]FishCommand(nil), msg.commands...)
+		if s.merger != nil {
+			s.merger = newTimelineMerger()
+			for _, cmd := range s.archived {
+				s.merger.Add(cmd)
 			}
-			currentCmd = FishCommand{
-				Command: strings.TrimPrefix(line, "- cmd: "),
+			for _, cmd := range s.primary {
+				s.merger.Add(cmd)
 			}
-			inPaths = false
-		} else if strings.HasPrefix(line, "when: ") {
-			timestampStr := strings.TrimPrefix(line, "when: ")
-			if timestamp, err := strconv.ParseInt(timestampStr, 10, 64); err == nil {
-				currentCmd.When = time.Unix(timestamp, 0)
+		}
+		s.rebuildHistory()
+		return true
+	}
+
+	if len(msg.commands) == 0 {
+		return false
+	}
+	s.logger.Infof("Picked up %d new commands from %s", len(msg.commands), s.source.Path())
+	s.diagnostics = append(s.diagnostics, msg.diagnostics...)
+	s.appendCommands(msg.commands, false)
+	return true
+}
+
+// GetLoadError returns why the last load failed, or nil
+func (s *FishHistoryService) GetLoadError() error {
+	return s.loadErr
+}
+
+// IsLoading returns whether history is still being streamed in
+func (s *FishHistoryService) IsLoading() bool {
+	return s.loader != nil
+}
+
+// GetLoadProgress returns how many bytes of the history files have been read
+// out of their total size
+func (s *FishHistoryService) GetLoadProgress() (int64, int64) {
+	return s.bytesRead, s.totalBytes
+}
+
+// appendCommands adds entries in file order to the newest-first history.
+// Entries newer than the history so far, the usual case for a file read from
+// start to end, are sorted among themselves and put in front. Anything else
+// needs a sort of the whole history, which waits until the load finishes.
+func (s *FishHistoryService) appendCommands(commands []FishCommand, archived bool) {
+	if archived {
+		s.archived = append(s.archived, commands...)
+	} else {
+		s.primary = append(s.primary, commands...)
+	}
+
+	added := append([]FishCommand(nil), commands...)
+	inOrder := true
+	if s.merger != nil {
+		added = added[:0]
+		for _, cmd := range commands {
+			if entry, ok := s.merger.Add(cmd); ok {
+				added = append(added, entry)
+			} else {
+				inOrder = false
 			}
-		} else if strings.HasPrefix(line, "paths:") {
-			inPaths = true
-		} else if inPaths && strings.HasPrefix(line, "- ") {
-			// Skip path entries for now
-			continue
 		}
 	}
+	sortNewestFirst(added)
+	if len(added) > 0 && len(s.history) > 0 && added[len(added)-1].When.Before(s.history[0].When) {
+		inOrder = false
+	}
 
-	if currentCmd.Command != "" && !currentCmd.When.IsZero() {
-		commands = append(commands, currentCmd)
+	switch {
+	case inOrder:
+		s.prependHistory(added)
+		s.rankAdded(added)
+	case s.loader != nil:
+		s.prependHistory(added)
+		s.unsorted = true
+		s.rankAdded(added)
+	default:
+		s.rebuildHistory()
 	}
+}
 
-	// Sort by timestamp (newest first)
-	sort.Slice(commands, func(i, j int) bool {
-		return commands[i].When.After(commands[j].When)
-	})
+// prependHistory puts newer commands in front of the history. Earlier
+// snapshots handed out by GetHistory stay valid as only the free space before
+// historyStart is written, and the buffer doubles when that runs out.
+func (s *FishHistoryService) prependHistory(commands []FishCommand) {
+	if len(commands) > s.historyStart {
+		size := max(2*len(s.history), len(s.history)+len(commands), 1024)
+		buf := make([]FishCommand, size)
+		s.historyStart = size - len(s.history)
+		copy(buf[s.historyStart:], s.history)
+		s.historyBuf = buf
+	}
+	s.historyStart -= len(commands)
+	copy(s.historyBuf[s.historyStart:], commands)
+	s.history = s.historyBuf[s.historyStart:]
+}
 
-	s.history = commands
-	s.historyLoaded = true
-	s.logger.Infof("Loaded %d fish history commands", len(commands))
-	return commands, nil
+// rebuildHistory sorts the whole history again into a new slice
+func (s *FishHistoryService) rebuildHistory() {
+	var history []FishCommand
+	if s.merger != nil {
+		history = s.merger.Commands()
+	} else {
+		history = append([]FishCommand(nil), s.primary...)
+		sortNewestFirst(history)
+	}
+	s.history = history
+	s.historyBuf = history
+	s.historyStart = 0
+	s.unsorted = false
+	s.rerank()
+}
+
+// rerank rebuilds the listed history from the stored one
+func (s *FishHistoryService) rerank() {
+	s.groups = nil
+	if !s.IsGrouped() {
+		s.ranked = s.history
+		return
+	}
+	s.groups = newCommandGroups(s.normalizeWhitespace)
+	s.groups.Add(s.history)
+	s.rankGroups()
+}
+
+// rankAdded lists commands just put in front of the history. Their runs are
+// folded into the groups, which are then ranked again. While loading that
+// waits until the number of groups has doubled, listing new groups last in
+// the meantime, and FinishLoad ranks them once more.
+func (s *FishHistoryService) rankAdded(added []FishCommand) {
+	if s.groups == nil {
+		s.ranked = s.history
+		return
+	}
+	before := len(s.groups.groups)
+	s.groups.Add(added)
+	if s.loader != nil && len(s.groups.groups) < 2*s.rankedGroups {
+		s.ranked = append(s.ranked, s.groups.groups[before:]...)
+		return
+	}
+	s.rankGroups()
+}
+
+// rankGroups lists the groups in rank order
+func (s *FishHistoryService) rankGroups() {
+	ranked := s.groups.Ordered()
+	rankCommands(ranked, s.rankMode, time.Now())
+	s.ranked = ranked
+	s.rankedGroups = len(ranked)
+}
+
+// reset drops the stored history and stops any running load
+func (s *FishHistoryService) reset() {
+	if s.loader != nil {
+		s.loader.Stop()
+		s.loader = nil
+	}
+	s.watcher = nil
+	s.history = []FishCommand{}
+	s.historyBuf = nil
+	s.historyStart = 0
+	s.unsorted = false
+	s.primary = nil
+	s.archived = nil
+	s.merger = nil
+	s.historyLoaded = false
+	s.loadErr = nil
+	s.diagnostics = nil
+	s.bytesRead = 0
+	s.totalBytes = 0
+	s.rerank()
+}
+
+// GetSource returns the active history source
+func (s *FishHistoryService) GetSource() HistorySource {
+	return s.source
+}
+
+// SetSource switches to another history source, keeping the archives. The
+// stored history is dropped until StartLoad is called again.
+func (s *FishHistoryService) SetSource(source HistorySource) {
+	s.source = source
+	s.reset()
+}
+
+// GetRankMode returns how the history is ordered
+func (s *FishHistoryService) GetRankMode() RankMode {
+	return s.rankMode
+}
+
+// SetRankMode changes how the history is ordered
+func (s *FishHistoryService) SetRankMode(mode RankMode) {
+	s.rankMode = mode
+	s.rerank()
+	s.logger.Infof("Rank mode set to %s", mode)
+}
+
+// CycleRankMode switches to the next rank mode and returns it
+func (s *FishHistoryService) CycleRankMode() RankMode {
+	s.SetRankMode((s.rankMode + 1) % (RankAlphabetical + 1))
+	return s.rankMode
+}
+
+// IsGrouped reports whether identical commands are listed once. That is
+// always the case unless the history is ordered by recency.
+func (s *FishHistoryService) IsGrouped() bool {
+	return s.grouped || s.rankMode != RankRecency
+}
+
+// SetGrouped sets whether identical commands are listed once in recency
+// order too
+func (s *FishHistoryService) SetGrouped(grouped bool) {
+	s.grouped = grouped
+	s.rerank()
+	s.logger.Infof("Grouping set to %t", grouped)
+}
+
+// SetNormalizeWhitespace sets whether commands that only differ in spacing
+// are grouped together
+func (s *FishHistoryService) SetNormalizeWhitespace(normalize bool) {
+	s.normalizeWhitespace = normalize
+	s.rerank()
+}
+
+// GetRanked returns the history as listed: ordered by the rank mode, with
+// identical commands grouped when IsGrouped is set
+func (s *FishHistoryService) GetRanked() []FishCommand {
+	return s.ranked
 }
 
-// GetLastCommands returns the last N commands from the stored history
+// GetNeighbors returns up to count commands run right before and right after
+// cmd, nearest first. For a grouped command that is around its latest run.
+func (s *FishHistoryService) GetNeighbors(cmd FishCommand, count int) (before, after []FishCommand) {
+	i := findCommand(slices.All(s.history), cmd)
+	if i < 0 {
+		return nil, nil
+	}
+	before = s.history[i+1 : min(i+1+count, len(s.history))]
+	for j := i - 1; j >= max(i-count, 0); j-- {
+		after = append(after, s.history[j])
+	}
+	return before, after
+}
+
+// GetLastCommands returns the first N commands of the ranked history
 func (s *FishHistoryService) GetLastCommands(count int) []FishCommand {
-	if len(s.history) < count {
-		count = len(s.history)
+	ranked := s.GetRanked()
+	if len(ranked) < count {
+		count = len(ranked)
 	}
-	return s.history[:count]
+	return ranked[:count]
 }
 
 // IsHistoryLoaded returns whether the history has been loaded
@@ -110,6 +446,11 @@ func (s *FishHistoryService) IsHistoryLoaded() bool {
 	return s.historyLoaded
 }
 
+// GetDiagnostics returns the problems found while parsing the history file
+func (s *FishHistoryService) GetDiagnostics() []ParseDiagnostic {
+	return s.diagnostics
+}
+
 // GetHistory returns the full stored history
 func (s *FishHistoryService) GetHistory() []FishCommand {
 	return s.history
@@ -117,15 +458,5 @@ func (s *FishHistoryService) GetHistory() []FishCommand {
 
 // FormatCommand formats a single command for display
 func (s *FishHistoryService) FormatCommand(cmd FishCommand, index int) string {
-	return fmt.Sprintf("%d. %s\n   %s", index+1, cmd.Command, cmd.When.Format("2006-01-02 15:04:05"))
-}
-
-// CreateHistoryMessage creates a fishHistoryMsg for the given commands
-func (s *FishHistoryService) CreateHistoryMessage(commands []FishCommand) fishHistoryMsg {
-	return fishHistoryMsg{commands: commands}
-}
-
-// CreateErrorMessage creates a fishHistoryMsg for the given error
-func (s *FishHistoryService) CreateErrorMessage(err error) fishHistoryMsg {
-	return fishHistoryMsg{err: err}
+	return fmt.Sprintf("%d. %s\n   %s", index+1, cmd.DisplayCommand(), cmd.FormatWhen())
 }
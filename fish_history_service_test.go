@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io"
+	"slices"
+	"testing"
+	"time"
+)
+
+// at builds a command run at the given minute
+func at(command string, minute int) FishCommand {
+	return FishCommand{Command: command, When: time.Date(2024, 1, 1, 0, minute, 0, 0, time.UTC)}
+}
+
+func commandTexts(commands []FishCommand) []string {
+	var texts []string
+	for _, cmd := range commands {
+		texts = append(texts, cmd.Command)
+	}
+	return texts
+}
+
+func TestAppendCommandsStreaming(t *testing.T) {
+	tests := []struct {
+		name     string
+		archived bool
+		batches  [][]FishCommand
+		want     []string
+	}{
+		{
+			name: "in order",
+			batches: [][]FishCommand{
+				{at("a", 1), at("b", 2)},
+				{at("c", 3), at("d", 3)},
+				{at("e", 4)},
+			},
+			want: []string{"e", "d", "c", "b", "a"},
+		},
+		{
+			name: "untimed entries keep reverse file order",
+			batches: [][]FishCommand{
+				{{Command: "a"}, {Command: "b"}},
+				{{Command: "c"}},
+			},
+			want: []string{"c", "b", "a"},
+		},
+		{
+			name: "out of order is sorted when the load finishes",
+			batches: [][]FishCommand{
+				{at("a", 5), at("b", 6)},
+				{at("c", 1), at("d", 7)},
+			},
+			want: []string{"d", "b", "a", "c"},
+		},
+		{
+			name:     "archived runs merge into existing entries",
+			archived: true,
+			batches: [][]FishCommand{
+				{at("a", 1), at("b", 2)},
+				{at("a", 3)},
+			},
+			want: []string{"a", "b"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewFishHistoryService(NewLoggerService(io.Discard, ERROR), nil, nil)
+			s.loader = &HistoryLoader{}
+			if tt.archived {
+				s.merger = newTimelineMerger()
+			}
+
+			var snapshots [][]FishCommand
+			var copies [][]string
+			for i, batch := range tt.batches {
+				s.appendCommands(batch, tt.archived && i > 0)
+				snapshots = append(snapshots, s.GetHistory())
+				copies = append(copies, commandTexts(s.GetHistory()))
+			}
+			s.FinishLoad(fishHistoryMsg{loader: s.loader})
+
+			if got := commandTexts(s.GetHistory()); !slices.Equal(got, tt.want) {
+				t.Errorf("history = %q, want %q", got, tt.want)
+			}
+			for i, snapshot := range snapshots {
+				if got := commandTexts(snapshot); !slices.Equal(got, copies[i]) {
+					t.Errorf("snapshot %d changed to %q, was %q", i, got, copies[i])
+				}
+			}
+		})
+	}
+}
+
+func TestAppendCommandsGrouped(t *testing.T) {
+	batches := [][]FishCommand{
+		{at("make", 1), at("vim", 2)},
+		{at("make", 3), at("ls", 4)},
+		{at("git", 5), at("make", 6), at("cd", 7), at("ls", 8)},
+	}
+	for _, mode := range []RankMode{RankRecency, RankFrecency, RankFrequency, RankAlphabetical} {
+		t.Run(mode.String(), func(t *testing.T) {
+			s := NewFishHistoryService(NewLoggerService(io.Discard, ERROR), nil, nil)
+			s.SetGrouped(true)
+			s.SetRankMode(mode)
+			s.loader = &HistoryLoader{}
+			for _, batch := range batches {
+				s.appendCommands(batch, false)
+			}
+			s.FinishLoad(fishHistoryMsg{loader: s.loader})
+			s.appendCommands([]FishCommand{at("vim", 9)}, false)
+
+			got := commandTexts(s.GetRanked())
+			s.rerank()
+			if want := commandTexts(s.GetRanked()); !slices.Equal(got, want) {
+				t.Errorf("ranked = %q, regrouped from scratch = %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Fuzzy scoring weights. A matched character is worth scoreMatch; matches at
+// the start of a word or right after the previous match earn extra, and every
+// character skipped inside a match costs a little.
+const (
+	scoreMatch       = 16
+	bonusBoundary    = 10
+	bonusFirstChar   = 8
+	bonusConsecutive = 12
+	penaltyGap       = 1
+	maxGapPenalty    = 24
+	maxRecencyBonus  = 30
+)
+
+// fuzzyText is a text prepared for fuzzy matching: its runes in lower case
+// and which of them start a word. The search index keeps one per command so
+// that a keystroke does not prepare every command again.
+type fuzzyText struct {
+	lower      []rune
+	wordStarts []bool
+}
+
+// newFuzzyText prepares text for fuzzy matching
+func newFuzzyText(text string) fuzzyText {
+	runes := []rune(text)
+	t := fuzzyText{
+		lower:      make([]rune, len(runes)),
+		wordStarts: make([]bool, len(runes)),
+	}
+	for i, r := range runes {
+		t.lower[i] = unicode.ToLower(r)
+		t.wordStarts[i] = isWordStart(runes, i)
+	}
+	return t
+}
+
+// fuzzyMatch matches every space separated term of query as a subsequence
+// of text, so "gco main" finds "git checkout main". It returns the score and
+// the matched rune positions in text, or false if a term does not match.
+func fuzzyMatch(text, query string) (int, []int, bool) {
+	terms := strings.Fields(strings.ToLower(query))
+	if len(terms) == 0 {
+		return 0, nil, true
+	}
+
+	t := newFuzzyText(text)
+	total := 0
+	var positions []int
+	for _, term := range terms {
+		score, matched, ok := t.matchTerm([]rune(term), nil)
+		if !ok {
+			return 0, nil, false
+		}
+		total += score
+		positions = append(positions, matched...)
+	}
+	return total, sortUniqueInts(positions), true
+}
+
+// score returns the score of a lower case term matched as a subsequence, like
+// matchTerm without keeping the positions
+func (t *fuzzyText) score(term string) (int, bool) {
+	var pattern [32]rune
+	var positions [32]int
+	score, _, ok := t.matchTerm(append(pattern[:0], []rune(term)...), positions[:0])
+	return score, ok
+}
+
+// matchTerm finds the tightest subsequence match of pattern: a forward
+// scan finds where the first full match ends, then a backward scan from
+// there finds the latest start, which keeps the matched characters close.
+// The matched positions are appended to positions.
+func (t *fuzzyText) matchTerm(pattern []rune, positions []int) (int, []int, bool) {
+	if len(pattern) == 0 {
+		return 0, positions, true
+	}
+	lower := t.lower
+
+	// Forward: earliest end of a full match
+	p, end := 0, -1
+	for i, r := range lower {
+		if r == pattern[p] {
+			p++
+			if p == len(pattern) {
+				end = i
+				break
+			}
+		}
+	}
+	if end < 0 {
+		return 0, positions, false
+	}
+
+	// Backward: latest start for that end
+	p = len(pattern) - 1
+	start := end
+	for i := end; i >= 0; i-- {
+		if lower[i] == pattern[p] {
+			p--
+			if p < 0 {
+				start = i
+				break
+			}
+		}
+	}
+
+	// Forward again within the window to pick the positions to score
+	first := len(positions)
+	p = 0
+	for i := start; i <= end && p < len(pattern); i++ {
+		if lower[i] == pattern[p] {
+			positions = append(positions, i)
+			p++
+		}
+	}
+
+	return t.scorePositions(positions[first:]), positions, true
+}
+
+// scorePositions scores a set of matched positions
+func (t *fuzzyText) scorePositions(positions []int) int {
+	score := 0
+	gaps := 0
+	for n, i := range positions {
+		score += scoreMatch
+		if t.wordStarts[i] {
+			score += bonusBoundary
+			if n == 0 {
+				score += bonusFirstChar
+			}
+		}
+		if n > 0 {
+			if i == positions[n-1]+1 {
+				score += bonusConsecutive
+			} else {
+				gaps += i - positions[n-1] - 1
+			}
+		}
+	}
+	return score - min(gaps*penaltyGap, maxGapPenalty)
+}
+
+// isWordStart reports whether the rune at i begins a word
+func isWordStart(runes []rune, i int) bool {
+	if i == 0 {
+		return true
+	}
+	prev, cur := runes[i-1], runes[i]
+	switch {
+	case unicode.IsSpace(prev), strings.ContainsRune("-_./:=|;&'\"(", prev):
+		return true
+	case unicode.IsLower(prev) && unicode.IsUpper(cur):
+		return true
+	}
+	return false
+}
+
+// recencyBonus favours commands run recently: a command run just now earns
+// maxRecencyBonus, decaying with the number of days since it ran
+func recencyBonus(when time.Time, now time.Time) int {
+	if when.IsZero() {
+		return 0
+	}
+	days := now.Sub(when).Hours() / 24
+	if days < 0 {
+		days = 0
+	}
+	return int(maxRecencyBonus / (1 + days))
+}
+
+// sortUniqueInts sorts positions and drops duplicates in place
+func sortUniqueInts(values []int) []int {
+	if len(values) < 2 {
+		return values
+	}
+	sort.Ints(values)
+	unique := values[:1]
+	for _, v := range values[1:] {
+		if v != unique[len(unique)-1] {
+			unique = append(unique, v)
+		}
+	}
+	return unique
+}
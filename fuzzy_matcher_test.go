@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestFuzzyMatch(t *testing.T) {
+	tests := []struct {
+		text          string
+		query         string
+		wantOK        bool
+		wantPositions []int
+	}{
+		{"git checkout main", "gco main", true, []int{0, 4, 9, 13, 14, 15, 16}},
+		{"Git Status", "gs", true, []int{0, 4}},
+		{"git status", "GST", true, []int{0, 4, 5}},
+		{"git status", "xyz", false, nil},
+		{"git status", "git xyz", false, nil},
+		{"git status", "", true, nil},
+		// The tightest window is picked, not the first character seen
+		{"make tests && make test", "test", true, []int{5, 6, 7, 8}},
+		{"a-b a_b ab", "ab", true, []int{0, 2}},
+		// Terms that match the same characters report them once
+		{"ls -la", "l l", true, []int{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text+"/"+tt.query, func(t *testing.T) {
+			_, positions, ok := fuzzyMatch(tt.text, tt.query)
+			if ok != tt.wantOK || !slices.Equal(positions, tt.wantPositions) {
+				t.Errorf("fuzzyMatch(%q, %q) = %v, %t, want %v, %t", tt.text, tt.query, positions, ok, tt.wantPositions, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestFuzzyMatchScoring(t *testing.T) {
+	tests := []struct {
+		name   string
+		query  string
+		better string
+		worse  string
+	}{
+		{"word start", "st", "git status", "git digest"},
+		{"first character at a word start", "gs", "git status", "xgit status"},
+		{"camel case", "fb", "fooBar", "foobar"},
+		{"consecutive", "stat", "git status", "s-t-a-t"},
+		{"shorter gap", "ab", "axb", "axxxxb"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			better, _, ok := fuzzyMatch(tt.better, tt.query)
+			if !ok {
+				t.Fatalf("%q does not match %q", tt.better, tt.query)
+			}
+			worse, _, ok := fuzzyMatch(tt.worse, tt.query)
+			if !ok {
+				t.Fatalf("%q does not match %q", tt.worse, tt.query)
+			}
+			if better <= worse {
+				t.Errorf("%q scores %d, not more than %q with %d", tt.better, better, tt.worse, worse)
+			}
+		})
+	}
+}
+
+func TestRecencyBonus(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		when time.Time
+		want int
+	}{
+		{time.Time{}, 0},
+		{now, maxRecencyBonus},
+		{now.Add(time.Hour), maxRecencyBonus},
+		{now.AddDate(0, 0, -1), maxRecencyBonus / 2},
+		{now.AddDate(0, 0, -29), 1},
+		{now.AddDate(-1, 0, 0), 0},
+	}
+
+	for _, tt := range tests {
+		if got := recencyBonus(tt.when, now); got != tt.want {
+			t.Errorf("recencyBonus(%v) = %d, want %d", tt.when, got, tt.want)
+		}
+	}
+}
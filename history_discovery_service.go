@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// historyPathEnv names the environment variable that overrides the history path
+const historyPathEnv = "BUBLSRC_HISTORY"
+
+// HistorySession describes one history file in the fish data directory
+type HistorySession struct {
+	Name    string
+	Path    string
+	Size    int64
+	ModTime time.Time
+}
+
+// HistoryDiscoveryService finds the history file to load
+type HistoryDiscoveryService struct {
+	logger *LoggerService
+}
+
+// NewHistoryDiscoveryService creates a new history discovery service
+func NewHistoryDiscoveryService(logger *LoggerService) *HistoryDiscoveryService {
+	return &HistoryDiscoveryService{
+		logger: logger,
+	}
+}
+
+// FishDataDir returns the directory fish keeps its history in, honouring
+// $XDG_DATA_HOME the same way fish does
+func (s *HistoryDiscoveryService) FishDataDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		dataHome = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(dataHome, "fish"), nil
+}
+
+// ResolveHistoryPath picks the history file to load for shell. An explicit
+// path from the command line wins, then $BUBLSRC_HISTORY, then the config
+// file, and finally the shell's default location.
+func (s *HistoryDiscoveryService) ResolveHistoryPath(shell, flagPath, configPath string) (string, error) {
+	if flagPath != "" {
+		s.logger.Debugf("Using history path from flag: %s", flagPath)
+		return expandHome(flagPath), nil
+	}
+	if envPath := os.Getenv(historyPathEnv); envPath != "" {
+		s.logger.Debugf("Using history path from $%s: %s", historyPathEnv, envPath)
+		return expandHome(envPath), nil
+	}
+	if configPath != "" {
+		s.logger.Debugf("Using history path from config: %s", configPath)
+		return configPath, nil
+	}
+
+	path, err := s.DefaultHistoryPath(shell)
+	if err != nil {
+		return "", err
+	}
+	s.logger.Debugf("Using discovered %s history path: %s", shell, path)
+	return path, nil
+}
+
+// DefaultHistoryPath returns where shell keeps its history by default. For
+// fish this is the file of the current session named by $fish_history; bash
+// and zsh honour $HISTFILE when it is exported.
+func (s *HistoryDiscoveryService) DefaultHistoryPath(shell string) (string, error) {
+	if shell == ShellBash || shell == ShellZsh {
+		if histFile := os.Getenv("HISTFILE"); histFile != "" {
+			return expandHome(histFile), nil
+		}
+	}
+
+	switch shell {
+	case ShellFish, "":
+		dataDir, err := s.FishDataDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(dataDir, sessionFileName(os.Getenv("fish_history"))), nil
+	case ShellBash, ShellZsh:
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("failed to get home directory: %w", err)
+		}
+		if shell == ShellZsh {
+			if zdotDir := os.Getenv("ZDOTDIR"); zdotDir != "" {
+				homeDir = zdotDir
+			}
+			return filepath.Join(homeDir, ".zsh_history"), nil
+		}
+		return filepath.Join(homeDir, ".bash_history"), nil
+	}
+	return "", fmt.Errorf("unsupported shell %q", shell)
+}
+
+// ListSessions returns every *_history file in the fish data directory,
+// most recently modified first
+func (s *HistoryDiscoveryService) ListSessions() ([]HistorySession, error) {
+	dataDir, err := s.FishDataDir()
+	if err != nil {
+		return nil, err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dataDir, "*_history"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list history files: %w", err)
+	}
+
+	var sessions []HistorySession
+	for _, path := range matches {
+		info, err := os.Stat(path)
+		if err != nil || info.IsDir() {
+			continue
+		}
+		sessions = append(sessions, HistorySession{
+			Name:    strings.TrimSuffix(filepath.Base(path), "_history"),
+			Path:    path,
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].ModTime.After(sessions[j].ModTime)
+	})
+
+	s.logger.Debugf("Found %d history sessions in %s", len(sessions), dataDir)
+	return sessions, nil
+}
+
+// sessionFileName maps the value of $fish_history to the file fish writes
+func sessionFileName(session string) string {
+	if session == "" || session == "default" {
+		return "fish_history"
+	}
+	return session + "_history"
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// historyBatchInterval is how often the loader hands decoded entries to the UI
+const historyBatchInterval = 100 * time.Millisecond
+
+// errLoadStopped aborts a load that has been superseded
+var errLoadStopped = errors.New("history load stopped")
+
+// historyBatchMsg carries entries decoded since the previous batch
+type historyBatchMsg struct {
+	loader   *HistoryLoader
+	commands []FishCommand
+	// archived is set for entries of an archive rather than the live source
+	archived   bool
+	bytesRead  int64
+	totalBytes int64
+}
+
+// HistoryLoader streams the entries of several sources in the background.
+// The first source is required; the others are archives that are skipped with
+// a warning when they cannot be read.
+type HistoryLoader struct {
+	logger  *LoggerService
+	sources []HistorySource
+	msgs    chan tea.Msg
+	stop    chan struct{}
+}
+
+// newHistoryLoader creates a loader and starts reading the sources
+func newHistoryLoader(logger *LoggerService, sources []HistorySource) *HistoryLoader {
+	loader := &HistoryLoader{
+		logger:  logger,
+		sources: sources,
+		msgs:    make(chan tea.Msg),
+		stop:    make(chan struct{}),
+	}
+	go loader.run()
+	return loader
+}
+
+// Next waits for the next batch or the final fishHistoryMsg. It is meant to be
+// used as a tea.Cmd and returns nil once the loader has finished.
+func (l *HistoryLoader) Next() tea.Msg {
+	msg, ok := <-l.msgs
+	if !ok {
+		return nil
+	}
+	return msg
+}
+
+// Stop abandons the load
+func (l *HistoryLoader) Stop() {
+	select {
+	case <-l.stop:
+	default:
+		close(l.stop)
+	}
+}
+
+// send hands a message to the UI unless the load was stopped
+func (l *HistoryLoader) send(msg tea.Msg) bool {
+	select {
+	case l.msgs <- msg:
+		return true
+	case <-l.stop:
+		return false
+	}
+}
+
+// run reads every source and reports batches until done
+func (l *HistoryLoader) run() {
+	defer close(l.msgs)
+
+	var totalBytes int64
+	for _, source := range l.sources {
+		if info, err := os.Stat(source.Path()); err == nil {
+			totalBytes += info.Size()
+		}
+	}
+
+	var diagnostics []ParseDiagnostic
+	var bytesBefore, followOffset int64
+	var followInfo os.FileInfo
+	for i, source := range l.sources {
+		counter := &countingReader{stop: l.stop}
+		var batch []FishCommand
+		lastFlush := time.Now()
+
+		flush := func() {
+			l.send(historyBatchMsg{
+				loader:     l,
+				commands:   batch,
+				archived:   i > 0,
+				bytesRead:  bytesBefore + counter.count,
+				totalBytes: totalBytes,
+			})
+			batch = nil
+			lastFlush = time.Now()
+		}
+
+		origins := []string{source.Label()}
+		sourceDiagnostics, err := decodeSource(source, counter, func(cmd FishCommand) {
+			cmd.Origins = origins
+			batch = append(batch, cmd)
+			if time.Since(lastFlush) >= historyBatchInterval {
+				flush()
+			}
+		})
+		if errors.Is(err, errLoadStopped) {
+			l.logger.Debugf("Stopped loading %s", source.Path())
+			return
+		}
+		if err != nil && i == 0 {
+			l.send(fishHistoryMsg{loader: l, diagnostics: diagnostics, err: err})
+			return
+		}
+		if err != nil {
+			l.logger.Warnf("Skipping archive %s: %v", source.Path(), err)
+		} else {
+			l.logger.Infof("Read %d bytes of %s history from %s (%s)", counter.count, source.Name(), source.Path(), source.Label())
+		}
+		if i == 0 {
+			// Remember how far the live file was read so it can be followed
+			if info, err := os.Stat(source.Path()); err == nil {
+				followInfo = info
+				followOffset = counter.count
+			}
+		}
+
+		for _, diagnostic := range sourceDiagnostics {
+			l.logger.Warnf("%s history %s parse problem at %s", source.Name(), source.Path(), diagnostic)
+		}
+		diagnostics = append(diagnostics, sourceDiagnostics...)
+
+		bytesBefore += counter.count
+		flush()
+	}
+
+	l.send(fishHistoryMsg{loader: l, diagnostics: diagnostics, followInfo: followInfo, followOffset: followOffset})
+}
+
+// decodeSource opens a source's history file and decodes it through counter
+func decodeSource(source HistorySource, counter *countingReader, emit func(FishCommand)) ([]ParseDiagnostic, error) {
+	if source.Path() == "" {
+		return nil, fmt.Errorf("no %s history file configured", source.Name())
+	}
+
+	file, err := os.Open(source.Path())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s history: %w", source.Name(), err)
+	}
+	defer file.Close()
+
+	counter.reader = file
+	diagnostics, err := source.Decode(counter, emit)
+	if errors.Is(err, errLoadStopped) {
+		return diagnostics, err
+	}
+	if err != nil {
+		return diagnostics, fmt.Errorf("failed to read %s history: %w", source.Name(), err)
+	}
+	return diagnostics, nil
+}
+
+// countingReader counts the bytes read so far and fails once stop is closed
+type countingReader struct {
+	reader io.Reader
+	count  int64
+	stop   chan struct{}
+}
+
+// Read implements io.Reader
+func (r *countingReader) Read(p []byte) (int, error) {
+	select {
+	case <-r.stop:
+		return 0, errLoadStopped
+	default:
+	}
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
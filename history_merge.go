@@ -0,0 +1,89 @@
+package main
+
+import (
+	"slices"
+	"sort"
+	"time"
+)
+
+// timelineMerger folds identical commands from several sources into single
+// entries that remember every time the command ran and where it came from
+type timelineMerger struct {
+	index    map[string]int
+	commands []FishCommand
+}
+
+// newTimelineMerger creates an empty merger
+func newTimelineMerger() *timelineMerger {
+	return &timelineMerger{
+		index: make(map[string]int),
+	}
+}
+
+// Add merges cmd into the timeline. For a command not seen before it returns
+// the new entry and true; otherwise cmd was folded into an existing entry.
+func (m *timelineMerger) Add(cmd FishCommand) (FishCommand, bool) {
+	i, ok := m.index[cmd.Command]
+	if !ok {
+		m.index[cmd.Command] = len(m.commands)
+		// Clipped so that merging later runs copies rather than shifting
+		// timestamps that were handed out with the entry
+		cmd.Timestamps = slices.Clip(cmd.RunTimes())
+		cmd.Origins = append([]string(nil), cmd.Origins...)
+		cmd.Paths = append([]string(nil), cmd.Paths...)
+		m.commands = append(m.commands, cmd)
+		return cmd, true
+	}
+
+	merged := &m.commands[i]
+	for _, when := range cmd.RunTimes() {
+		merged.Timestamps = insertTimestamp(merged.Timestamps, when)
+	}
+	merged.When = merged.Timestamps[0]
+	merged.Origins = appendUnique(merged.Origins, cmd.Origins...)
+	merged.Paths = appendUnique(merged.Paths, cmd.Paths...)
+	return *merged, false
+}
+
+// Commands returns the merged entries, newest first
+func (m *timelineMerger) Commands() []FishCommand {
+	for i := range m.commands {
+		m.commands[i].Timestamps = slices.Clip(m.commands[i].Timestamps)
+	}
+	commands := append([]FishCommand(nil), m.commands...)
+	sortNewestFirst(commands)
+	return commands
+}
+
+// insertTimestamp adds when to timestamps kept newest first, skipping exact
+// duplicates: the same run copied into two archives is still one run. Runs
+// without a time cannot be told apart, so each of them is kept.
+func insertTimestamp(timestamps []time.Time, when time.Time) []time.Time {
+	i := sort.Search(len(timestamps), func(i int) bool {
+		return !timestamps[i].After(when)
+	})
+	if i < len(timestamps) && timestamps[i].Equal(when) && !when.IsZero() {
+		return timestamps
+	}
+	timestamps = append(timestamps, time.Time{})
+	copy(timestamps[i+1:], timestamps[i:])
+	timestamps[i] = when
+	return timestamps
+}
+
+// appendUnique appends the values that are not in list yet
+func appendUnique(list []string, values ...string) []string {
+	for _, value := range values {
+		found := false
+		for _, existing := range list {
+			if existing == value {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, value)
+		}
+	}
+	return list
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestTimelineMerger(t *testing.T) {
+	run := func(minute int) time.Time {
+		return at("", minute).When
+	}
+	tests := []struct {
+		name     string
+		commands []FishCommand
+		want     []FishCommand
+	}{
+		{
+			name: "runs from several sources",
+			commands: []FishCommand{
+				{Command: "make", When: run(1), Origins: []string{"live"}},
+				{Command: "ls", When: run(2), Origins: []string{"live"}},
+				{Command: "make", When: run(3), Origins: []string{"archive"}},
+			},
+			want: []FishCommand{
+				{Command: "make", Timestamps: []time.Time{run(3), run(1)}, Origins: []string{"live", "archive"}},
+				{Command: "ls", Timestamps: []time.Time{run(2)}, Origins: []string{"live"}},
+			},
+		},
+		{
+			name: "a run copied into two archives counts once",
+			commands: []FishCommand{
+				{Command: "make", When: run(1), Origins: []string{"a"}},
+				{Command: "make", When: run(1), Origins: []string{"b"}},
+			},
+			want: []FishCommand{
+				{Command: "make", Timestamps: []time.Time{run(1)}, Origins: []string{"a", "b"}},
+			},
+		},
+		{
+			name: "untimed runs are all kept",
+			commands: []FishCommand{
+				{Command: "make"},
+				{Command: "make"},
+				{Command: "make", When: run(1)},
+				{Command: "make"},
+			},
+			want: []FishCommand{
+				{Command: "make", Timestamps: []time.Time{run(1), {}, {}, {}}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merger := newTimelineMerger()
+			for _, cmd := range tt.commands {
+				merger.Add(cmd)
+			}
+			got := merger.Commands()
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", commandTexts(got), commandTexts(tt.want))
+			}
+			for i, want := range tt.want {
+				if got[i].Command != want.Command || !slices.Equal(got[i].Timestamps, want.Timestamps) || !slices.Equal(got[i].Origins, want.Origins) {
+					t.Errorf("entry %d = %q %v %q, want %q %v %q", i, got[i].Command, got[i].Timestamps, got[i].Origins, want.Command, want.Timestamps, want.Origins)
+				}
+			}
+		})
+	}
+}
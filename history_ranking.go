@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+// RankMode selects how the history and the search results are ordered
+type RankMode int
+
+const (
+	// RankRecency lists every run, newest first
+	RankRecency RankMode = iota
+	// RankFrecency lists each command once, favouring commands run often
+	// and recently
+	RankFrecency
+	// RankFrequency lists each command once, most runs first
+	RankFrequency
+	// RankAlphabetical lists each command once, in alphabetical order
+	RankAlphabetical
+)
+
+// frecencyHalfLife is how long it takes a run to count half as much. A
+// command run every day keeps a high score, while a burst of runs months ago
+// fades away.
+const frecencyHalfLife = 7 * 24 * time.Hour
+
+// frecencyUntimedWeight is what a run without a timestamp counts for
+const frecencyUntimedWeight = 0.1
+
+// String returns the name shown for the mode
+func (m RankMode) String() string {
+	switch m {
+	case RankFrecency:
+		return "frecency"
+	case RankFrequency:
+		return "frequency"
+	case RankAlphabetical:
+		return "alphabetical"
+	}
+	return "recency"
+}
+
+// ParseRankMode parses the name of a rank mode
+func ParseRankMode(name string) (RankMode, error) {
+	for mode := RankRecency; mode <= RankAlphabetical; mode++ {
+		if strings.EqualFold(name, mode.String()) {
+			return mode, nil
+		}
+	}
+	return RankRecency, fmt.Errorf("unknown rank mode %q, use recency, frecency, frequency or alphabetical", name)
+}
+
+// commandGroups folds the runs of identical commands into one entry each as
+// the history grows. With normalizeWhitespace, commands that only differ in
+// spacing are identical; a group keeps the text of its latest run.
+type commandGroups struct {
+	normalizeWhitespace bool
+	index               map[string]int
+	groups              []FishCommand
+	// latest numbers the latest run of each group, higher is newer
+	latest []int
+	runs   int
+}
+
+// newCommandGroups creates an empty set of groups
+func newCommandGroups(normalizeWhitespace bool) *commandGroups {
+	return &commandGroups{
+		normalizeWhitespace: normalizeWhitespace,
+		index:               make(map[string]int),
+	}
+}
+
+// Add folds newest-first commands, all newer than those added before, into
+// the groups, listing every run in Timestamps. A group is copied before it
+// changes, so the ones handed out by Ordered stay as they were.
+func (g *commandGroups) Add(commands []FishCommand) {
+	touched := make(map[int]bool)
+	for i := len(commands) - 1; i >= 0; i-- {
+		cmd := commands[i]
+		key := cmd.Command
+		if g.normalizeWhitespace {
+			key = strings.Join(strings.Fields(key), " ")
+		}
+		g.runs++
+
+		j, ok := g.index[key]
+		if !ok {
+			j = len(g.groups)
+			g.index[key] = j
+			cmd.Timestamps = slices.Clone(cmd.RunTimes())
+			g.groups = append(g.groups, cmd)
+			g.latest = append(g.latest, g.runs)
+			touched[j] = true
+			continue
+		}
+		group := &g.groups[j]
+		if !touched[j] {
+			touched[j] = true
+			group.Timestamps = slices.Clone(group.Timestamps)
+		}
+		group.Timestamps = append(group.Timestamps, cmd.RunTimes()...)
+		group.Command = cmd.Command
+		group.When = cmd.When
+		group.Origins = appendUnique(slices.Clip(group.Origins), cmd.Origins...)
+		group.Paths = appendUnique(slices.Clip(group.Paths), cmd.Paths...)
+		g.latest[j] = g.runs
+	}
+
+	for j := range touched {
+		slices.SortStableFunc(g.groups[j].Timestamps, func(a, b time.Time) int {
+			return b.Compare(a)
+		})
+	}
+}
+
+// Ordered returns the groups latest run first, the order rankCommands
+// expects
+func (g *commandGroups) Ordered() []FishCommand {
+	order := make([]int, len(g.groups))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortFunc(order, func(a, b int) int {
+		return g.latest[b] - g.latest[a]
+	})
+	groups := make([]FishCommand, len(order))
+	for i, j := range order {
+		groups[i] = g.groups[j]
+	}
+	return groups
+}
+
+// rankCommands sorts newest-first commands in place by mode. Every mode but
+// recency expects grouped commands, see commandGroups.
+func rankCommands(commands []FishCommand, mode RankMode, now time.Time) {
+	switch mode {
+	case RankFrecency:
+		scores := make(map[string]float64, len(commands))
+		for _, cmd := range commands {
+			scores[cmd.Command] = frecencyScore(cmd.RunTimes(), now)
+		}
+		sort.SliceStable(commands, func(i, j int) bool {
+			return scores[commands[i].Command] > scores[commands[j].Command]
+		})
+	case RankFrequency:
+		sort.SliceStable(commands, func(i, j int) bool {
+			return len(commands[i].RunTimes()) > len(commands[j].RunTimes())
+		})
+	case RankAlphabetical:
+		sort.SliceStable(commands, func(i, j int) bool {
+			a, b := strings.ToLower(commands[i].Command), strings.ToLower(commands[j].Command)
+			if a != b {
+				return a < b
+			}
+			return commands[i].Command < commands[j].Command
+		})
+	}
+}
+
+// frecencyScore adds up the runs of a command, each weighted by how long ago
+// it was: a run now counts 1, a run frecencyHalfLife ago counts 0.5
+func frecencyScore(runs []time.Time, now time.Time) float64 {
+	score := 0.0
+	for _, when := range runs {
+		if when.IsZero() {
+			score += frecencyUntimedWeight
+			continue
+		}
+		age := max(now.Sub(when), 0)
+		score += math.Exp2(-float64(age) / float64(frecencyHalfLife))
+	}
+	return score
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCommandGroups(t *testing.T) {
+	// Newest first, as in the history
+	history := []FishCommand{
+		at("ls  -la", 9),
+		at("make", 8),
+		at("ls -la", 7),
+		at("git status", 6),
+		at("make", 5),
+		at("ls -la", 4),
+		{Command: "git status"},
+	}
+	minutes := func(runs ...int) []time.Time {
+		var times []time.Time
+		for _, minute := range runs {
+			times = append(times, at("", minute).When)
+		}
+		return times
+	}
+
+	tests := []struct {
+		name      string
+		normalize bool
+		batches   []int
+		want      []FishCommand
+	}{
+		{
+			name:    "all at once",
+			batches: []int{7},
+			want: []FishCommand{
+				{Command: "ls  -la", Timestamps: minutes(9)},
+				{Command: "make", Timestamps: minutes(8, 5)},
+				{Command: "ls -la", Timestamps: minutes(7, 4)},
+				{Command: "git status", Timestamps: append(minutes(6), time.Time{})},
+			},
+		},
+		{
+			name:      "normalized whitespace keeps the latest text",
+			normalize: true,
+			batches:   []int{7},
+			want: []FishCommand{
+				{Command: "ls  -la", Timestamps: minutes(9, 7, 4)},
+				{Command: "make", Timestamps: minutes(8, 5)},
+				{Command: "git status", Timestamps: append(minutes(6), time.Time{})},
+			},
+		},
+		{
+			name:      "in batches",
+			normalize: true,
+			batches:   []int{2, 2, 3},
+			want: []FishCommand{
+				{Command: "ls  -la", Timestamps: minutes(9, 7, 4)},
+				{Command: "make", Timestamps: minutes(8, 5)},
+				{Command: "git status", Timestamps: append(minutes(6), time.Time{})},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups := newCommandGroups(tt.normalize)
+			var first []FishCommand
+			end := len(history)
+			for i, size := range tt.batches {
+				groups.Add(history[end-size : end])
+				end -= size
+				if i == 0 {
+					first = groups.Ordered()
+				}
+			}
+			firstTimes := make([][]time.Time, len(first))
+			for i, group := range first {
+				firstTimes[i] = slices.Clone(group.Timestamps)
+			}
+
+			got := groups.Ordered()
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %q, want %q", commandTexts(got), commandTexts(tt.want))
+			}
+			for i, want := range tt.want {
+				if got[i].Command != want.Command || !slices.Equal(got[i].Timestamps, want.Timestamps) {
+					t.Errorf("group %d = %q %v, want %q %v", i, got[i].Command, got[i].Timestamps, want.Command, want.Timestamps)
+				}
+			}
+			for i, group := range first {
+				if !slices.Equal(group.Timestamps, firstTimes[i]) {
+					t.Errorf("earlier group %q changed to %v", group.Command, group.Timestamps)
+				}
+			}
+		})
+	}
+}
+
+func TestRankCommands(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	runs := func(when time.Time, count int) []time.Time {
+		times := make([]time.Time, count)
+		for i := range times {
+			times[i] = when
+		}
+		return times
+	}
+	// Grouped and latest run first, as rankCommands expects
+	commands := []FishCommand{
+		{Command: "vim notes", Timestamps: runs(now.Add(-time.Hour), 2)},
+		{Command: "make", Timestamps: runs(now.AddDate(0, -2, 0), 10)},
+		{Command: "cd", Timestamps: runs(now.AddDate(0, -2, 0), 2)},
+		{Command: "Make", Timestamps: runs(time.Time{}, 5)},
+	}
+
+	tests := []struct {
+		mode RankMode
+		want []string
+	}{
+		{RankRecency, []string{"vim notes", "make", "cd", "Make"}},
+		// Two runs an hour ago beat ten runs two months ago, and untimed runs
+		// count for a little
+		{RankFrecency, []string{"vim notes", "Make", "make", "cd"}},
+		{RankFrequency, []string{"make", "Make", "vim notes", "cd"}},
+		{RankAlphabetical, []string{"cd", "Make", "make", "vim notes"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.mode.String(), func(t *testing.T) {
+			ranked := slices.Clone(commands)
+			rankCommands(ranked, tt.mode, now)
+			if got := commandTexts(ranked); !slices.Equal(got, tt.want) {
+				t.Errorf("ranked = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRankCommandsKeepsTies(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	commands := []FishCommand{
+		{Command: "b", When: now},
+		{Command: "a", When: now},
+		{Command: "c", When: now},
+	}
+	for _, mode := range []RankMode{RankFrecency, RankFrequency} {
+		ranked := slices.Clone(commands)
+		rankCommands(ranked, mode, now)
+		if got, want := commandTexts(ranked), []string{"b", "a", "c"}; !slices.Equal(got, want) {
+			t.Errorf("%s ranked ties as %q, want %q", mode, got, want)
+		}
+	}
+}
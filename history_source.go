@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Supported shells
+const (
+	ShellFish = "fish"
+	ShellBash = "bash"
+	ShellZsh  = "zsh"
+)
+
+// HistorySource reads the history file of one shell
+type HistorySource interface {
+	// Name returns the shell the source reads, e.g. "fish"
+	Name() string
+	// Path returns the history file the source reads
+	Path() string
+	// Label names the origin of the source's entries, such as a host
+	Label() string
+	// Decode parses history from r, calling emit for every complete entry in
+	// file order, and returns the problems it found along the way
+	Decode(r io.Reader, emit func(FishCommand)) ([]ParseDiagnostic, error)
+	// Finished returns how much of data, read from the end of a file the
+	// shell is appending to, holds entries that cannot gain more lines
+	Finished(data []byte) int
+}
+
+// NewHistorySource creates the source for the given shell and history file.
+// An empty label defaults to the name of the file.
+func NewHistorySource(shell, path, label string) (HistorySource, error) {
+	if label == "" {
+		label = filepath.Base(path)
+	}
+
+	switch shell {
+	case ShellFish, "":
+		return &FishHistorySource{path: path, label: label}, nil
+	case ShellBash:
+		return &BashHistorySource{path: path, label: label}, nil
+	case ShellZsh:
+		return &ZshHistorySource{path: path, label: label}, nil
+	}
+	return nil, fmt.Errorf("unsupported shell %q (want fish, bash or zsh)", shell)
+}
+
+// ParseSourceSpec splits a source given on the command line as
+// [shell:]path[=label] into its parts
+func ParseSourceSpec(spec, defaultShell string) (SourceConfig, error) {
+	config := SourceConfig{Shell: defaultShell}
+	path := spec
+
+	if shell, rest, found := strings.Cut(path, ":"); found {
+		switch shell {
+		case ShellFish, ShellBash, ShellZsh:
+			config.Shell = shell
+			path = rest
+		}
+	}
+	if before, label, found := strings.Cut(path, "="); found {
+		path = before
+		config.Label = label
+	}
+	if path == "" {
+		return config, fmt.Errorf("source %q has no path", spec)
+	}
+	config.Path = expandHome(path)
+	return config, nil
+}
+
+// sortNewestFirst orders commands read in file order by timestamp, newest
+// first. Entries with equal or missing timestamps keep the reverse of their
+// file order, so the most recently written still comes first.
+func sortNewestFirst(commands []FishCommand) {
+	for i, j := 0, len(commands)-1; i < j; i, j = i+1, j-1 {
+		commands[i], commands[j] = commands[j], commands[i]
+	}
+	sort.SliceStable(commands, func(i, j int) bool {
+		return commands[i].When.After(commands[j].When)
+	})
+}
+
+// FishHistorySource reads fish's fish_history files
+type FishHistorySource struct {
+	path  string
+	label string
+}
+
+// Name returns the shell the source reads
+func (s *FishHistorySource) Name() string {
+	return ShellFish
+}
+
+// Path returns the history file the source reads
+func (s *FishHistorySource) Path() string {
+	return s.path
+}
+
+// Label returns the origin recorded on the source's entries
+func (s *FishHistorySource) Label() string {
+	return s.label
+}
+
+// Decode parses fish history from r
+func (s *FishHistorySource) Decode(r io.Reader, emit func(FishCommand)) ([]ParseDiagnostic, error) {
+	decoder := NewFishHistoryDecoder(r)
+	for {
+		cmd, ok := decoder.Next()
+		if !ok {
+			break
+		}
+		emit(cmd)
+	}
+	return decoder.Diagnostics(), decoder.Err()
+}
+
+// Finished returns where the last item of data starts: fish writes its
+// when: and paths: lines after the "- cmd:" line, so any item may still grow
+// until the next one begins
+func (s *FishHistorySource) Finished(data []byte) int {
+	return bytes.LastIndex(data, []byte("\n- ")) + 1
+}
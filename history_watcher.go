@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// historyWatchInterval is how often the live history file is checked
+const historyWatchInterval = time.Second
+
+// historyWatchMsg reports what changed in the live history file since the
+// previous check
+type historyWatchMsg struct {
+	watcher     *HistoryWatcher
+	commands    []FishCommand
+	diagnostics []ParseDiagnostic
+	// rewritten is set when the file was replaced or truncated, in which case
+	// commands holds the whole file rather than the new entries
+	rewritten bool
+	err       error
+}
+
+// HistoryWatcher follows the live history file for entries written by other
+// shells. It polls the file and decodes only the bytes appended since the
+// last check, holding back an entry that may still be written until it is
+// finished. When the shell compacts the history, which replaces or shrinks
+// the file, the whole file is decoded again.
+type HistoryWatcher struct {
+	source HistorySource
+	info   os.FileInfo
+	offset int64
+}
+
+// newHistoryWatcher creates a watcher for a file already read up to offset
+func newHistoryWatcher(source HistorySource, info os.FileInfo, offset int64) *HistoryWatcher {
+	return &HistoryWatcher{
+		source: source,
+		info:   info,
+		offset: offset,
+	}
+}
+
+// Tick schedules the next check of the file
+func (w *HistoryWatcher) Tick() tea.Cmd {
+	return tea.Tick(historyWatchInterval, func(time.Time) tea.Msg {
+		return w.Poll()
+	})
+}
+
+// Poll checks the file once and returns a historyWatchMsg
+func (w *HistoryWatcher) Poll() tea.Msg {
+	msg := historyWatchMsg{watcher: w}
+
+	info, err := os.Stat(w.source.Path())
+	if err != nil {
+		msg.err = err
+		return msg
+	}
+
+	rewritten := !os.SameFile(info, w.info) || info.Size() < w.offset
+	if !rewritten && info.Size() == w.offset {
+		w.info = info
+		return msg
+	}
+
+	file, err := os.Open(w.source.Path())
+	if err != nil {
+		msg.err = err
+		return msg
+	}
+	defer file.Close()
+
+	start := w.offset
+	if rewritten {
+		start = 0
+	}
+	data, err := io.ReadAll(io.NewSectionReader(file, start, info.Size()-start))
+	if err != nil {
+		msg.err = fmt.Errorf("failed to read %s history: %w", w.source.Name(), err)
+		return msg
+	}
+
+	// While the file grows its last entry may be half written, as fish
+	// writes an item line by line: it is decoded once the next entry starts
+	// or the file stops growing. A partially written line always waits.
+	complete := bytes.LastIndexByte(data, '\n') + 1
+	if rewritten || info.Size() != w.info.Size() {
+		complete = w.source.Finished(data[:complete])
+	}
+	data = data[:complete]
+
+	origins := []string{w.source.Label()}
+	msg.diagnostics, msg.err = w.source.Decode(bytes.NewReader(data), func(cmd FishCommand) {
+		cmd.Origins = origins
+		msg.commands = append(msg.commands, cmd)
+	})
+	if msg.err != nil {
+		return msg
+	}
+
+	msg.rewritten = rewritten
+	w.info = info
+	w.offset = start + int64(complete)
+	return msg
+}
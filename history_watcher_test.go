@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestHistoryWatcherSplitWrites(t *testing.T) {
+	// Each step appends to the file, then polls it
+	steps := []struct {
+		write string
+		want  []FishCommand
+	}{
+		{write: "- cmd: make\n"},
+		{write: "  when: 1700000000\n"},
+		// The file stopped growing, so the item is finished
+		{write: "", want: []FishCommand{{Command: "make", When: time.Unix(1700000000, 0)}}},
+		{write: "- cmd: vim a.go\n  when: 1700000100\n  paths:\n"},
+		{write: "    - a.go\n"},
+		// The next item finishes the one before
+		{write: "- cmd: ls\n  when: 1700000200\n", want: []FishCommand{{Command: "vim a.go", When: time.Unix(1700000100, 0), Paths: []string{"a.go"}}}},
+		{write: "", want: []FishCommand{{Command: "ls", When: time.Unix(1700000200, 0)}}},
+		{write: "", want: nil},
+	}
+
+	path := filepath.Join(t.TempDir(), "fish_history")
+	if err := os.WriteFile(path, []byte("- cmd: echo old\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watcher := newHistoryWatcher(&FishHistorySource{path: path, label: "test"}, info, info.Size())
+
+	for i, step := range steps {
+		file, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.WriteString(step.write); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		msg := watcher.Poll().(historyWatchMsg)
+		if msg.err != nil {
+			t.Fatalf("step %d: %v", i, msg.err)
+		}
+		var got []FishCommand
+		for _, cmd := range msg.commands {
+			cmd.Origins = nil
+			got = append(got, cmd)
+		}
+		if !reflect.DeepEqual(got, step.want) {
+			t.Errorf("step %d: commands = %+v, want %+v", i, got, step.want)
+		}
+	}
+}
+
+func TestFinishedEntries(t *testing.T) {
+	tests := []struct {
+		name   string
+		source HistorySource
+		data   string
+		want   int
+	}{
+		{name: "fish item may grow", source: &FishHistorySource{}, data: "- cmd: ls\n  when: 1\n", want: 0},
+		{name: "fish next item", source: &FishHistorySource{}, data: "- cmd: ls\n- cmd: pwd\n", want: 10},
+		{name: "bash plain lines", source: &BashHistorySource{}, data: "ls\npwd\n", want: 7},
+		{name: "bash timestamped", source: &BashHistorySource{}, data: "#1\nls\n#2\npwd\n", want: 6},
+		{name: "zsh finished", source: &ZshHistorySource{}, data: ": 1:0;ls\n", want: 9},
+		{name: "zsh continued", source: &ZshHistorySource{}, data: ": 1:0;ls\n: 2:0;echo \\\n", want: 9},
+	}
+	for _, tt := range tests {
+		if got := tt.source.Finished([]byte(tt.data)); got != tt.want {
+			t.Errorf("%s: Finished = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
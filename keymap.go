@@ -0,0 +1,355 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/bubbles/key"
+)
+
+// KeyMap binds every action of the app to its keys. Which bindings apply
+// depends on the screen; see the *Bindings methods.
+type KeyMap struct {
+	Up       key.Binding
+	Down     key.Binding
+	PageUp   key.Binding
+	PageDown key.Binding
+	Home     key.Binding
+	End      key.Binding
+	// Copy copies the selected command, Select picks a session or confirms
+	// a path
+	Copy   key.Binding
+	Select key.Binding
+	Search key.Binding
+	// Back leaves search, the session picker or the path prompt, and quits
+	// from the history
+	Back      key.Binding
+	Quit      key.Binding
+	MatchMode key.Binding
+	RankMode  key.Binding
+	Group     key.Binding
+	Expand    key.Binding
+	Preview   key.Binding
+	Sessions  key.Binding
+	Help      key.Binding
+	Retry     key.Binding
+	EditPath  key.Binding
+	// Vim bindings, unbound unless Modal. Top is pressed twice, as in gg.
+	Top          key.Binding
+	Bottom       key.Binding
+	HalfPageUp   key.Binding
+	HalfPageDown key.Binding
+	NextMatch    key.Binding
+	PrevMatch    key.Binding
+	Yank         key.Binding
+	// Modal stops printable keys from starting a search in the history view,
+	// where they can take a count prefix instead, and makes Select confirm a
+	// search so that NextMatch and PrevMatch step through its matches
+	Modal bool
+}
+
+// keyNames are the short names shown in help for keys with a symbol
+var keyNames = map[string]string{
+	"up":     "↑",
+	"down":   "↓",
+	"left":   "←",
+	"right":  "→",
+	"pgup":   "PgUp",
+	"pgdown": "PgDn",
+	"home":   "Home",
+	"end":    "End",
+	"enter":  "Enter",
+	"esc":    "Esc",
+	"tab":    "Tab",
+	"f1":     "F1",
+}
+
+// newBinding creates a binding whose help lists its keys, so that the help
+// always shows the keys that are bound
+func newBinding(desc string, keys ...string) key.Binding {
+	binding := key.NewBinding(key.WithKeys(keys...))
+	setBindingKeys(&binding, desc, keys)
+	return binding
+}
+
+// setBindingKeys rebinds a binding to keys, disabling it when there are none
+func setBindingKeys(binding *key.Binding, desc string, keys []string) {
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k
+		if name, ok := keyNames[k]; ok {
+			names[i] = name
+		} else if strings.HasPrefix(k, "ctrl+") {
+			names[i] = "Ctrl+" + strings.ToUpper(strings.TrimPrefix(k, "ctrl+"))
+		}
+	}
+	binding.SetKeys(keys...)
+	binding.SetHelp(strings.Join(names, "/"), desc)
+	binding.SetEnabled(len(keys) > 0)
+}
+
+// DefaultKeyMap returns the default bindings
+func DefaultKeyMap() KeyMap {
+	return KeyMap{
+		Up:        newBinding("up", "up", "ctrl+k"),
+		Down:      newBinding("down", "down", "ctrl+j"),
+		PageUp:    newBinding("page up", "pgup"),
+		PageDown:  newBinding("page down", "pgdown"),
+		Home:      newBinding("first", "home"),
+		End:       newBinding("last", "end"),
+		Copy:      newBinding("copy", "enter"),
+		Select:    newBinding("open", "enter"),
+		Search:    newBinding("search", "/"),
+		Back:      newBinding("back", "esc"),
+		Quit:      newBinding("quit", "ctrl+c"),
+		MatchMode: newBinding("switch matching", "ctrl+f"),
+		RankMode:  newBinding("change order", "ctrl+r"),
+		Group:     newBinding("group", "ctrl+g"),
+		Expand:    newBinding("list runs", "tab"),
+		Preview:   newBinding("details", "ctrl+p"),
+		Sessions:  newBinding("sessions", "ctrl+o"),
+		Help:      newBinding("help", "?", "f1"),
+		Retry:     newBinding("retry", "r"),
+		EditPath:  newBinding("enter another path", "p", "e"),
+		// Vim bindings
+		Top:          newBinding("first"),
+		Bottom:       newBinding("last"),
+		HalfPageUp:   newBinding("half page up"),
+		HalfPageDown: newBinding("half page down"),
+		NextMatch:    newBinding("next match"),
+		PrevMatch:    newBinding("previous match"),
+		Yank:         newBinding("copy"),
+	}
+}
+
+// VimKeyMap returns the default bindings with vim navigation added
+func VimKeyMap() KeyMap {
+	km := DefaultKeyMap()
+	km.Modal = true
+	setBindingKeys(&km.Up, "up", []string{"k", "up", "ctrl+k"})
+	setBindingKeys(&km.Down, "down", []string{"j", "down", "ctrl+j"})
+	setBindingKeys(&km.Quit, "quit", []string{"q", "ctrl+c"})
+	setBindingKeys(&km.Top, "first", []string{"g"})
+	setBindingKeys(&km.Bottom, "last", []string{"G"})
+	setBindingKeys(&km.HalfPageUp, "half page up", []string{"ctrl+u"})
+	setBindingKeys(&km.HalfPageDown, "half page down", []string{"ctrl+d"})
+	setBindingKeys(&km.NextMatch, "next match", []string{"n"})
+	setBindingKeys(&km.PrevMatch, "previous match", []string{"N"})
+	setBindingKeys(&km.Yank, "copy", []string{"y"})
+	km.describeTop()
+	return km
+}
+
+// KeyMapNamed returns the keymap called name: default or vim
+func KeyMapNamed(name string) (KeyMap, error) {
+	switch strings.ToLower(name) {
+	case "", "default":
+		return DefaultKeyMap(), nil
+	case "vim":
+		return VimKeyMap(), nil
+	}
+	return DefaultKeyMap(), fmt.Errorf("unknown keymap %q, use default or vim", name)
+}
+
+// describeTop shows the keys of Top doubled in help, as they are pressed
+func (km *KeyMap) describeTop() {
+	keys := km.Top.Keys()
+	names := make([]string, len(keys))
+	for i, k := range keys {
+		names[i] = k + k
+	}
+	km.Top.SetHelp(strings.Join(names, "/"), km.Top.Help().Desc)
+}
+
+// named returns the bindings by the names used in the config file
+func (km *KeyMap) named() map[string]*key.Binding {
+	return map[string]*key.Binding{
+		"up":             &km.Up,
+		"down":           &km.Down,
+		"page_up":        &km.PageUp,
+		"page_down":      &km.PageDown,
+		"home":           &km.Home,
+		"end":            &km.End,
+		"copy":           &km.Copy,
+		"select":         &km.Select,
+		"search":         &km.Search,
+		"back":           &km.Back,
+		"quit":           &km.Quit,
+		"match_mode":     &km.MatchMode,
+		"rank_mode":      &km.RankMode,
+		"group":          &km.Group,
+		"expand":         &km.Expand,
+		"preview":        &km.Preview,
+		"sessions":       &km.Sessions,
+		"help":           &km.Help,
+		"retry":          &km.Retry,
+		"edit_path":      &km.EditPath,
+		"top":            &km.Top,
+		"bottom":         &km.Bottom,
+		"half_page_up":   &km.HalfPageUp,
+		"half_page_down": &km.HalfPageDown,
+		"next_match":     &km.NextMatch,
+		"prev_match":     &km.PrevMatch,
+		"yank":           &km.Yank,
+	}
+}
+
+// ApplyOverrides rebinds actions to the keys given by name in the config
+// file. An empty list of keys unbinds the action.
+func (km *KeyMap) ApplyOverrides(overrides map[string][]string) error {
+	named := km.named()
+	for name, keys := range overrides {
+		binding, ok := named[name]
+		if !ok {
+			return fmt.Errorf("unknown key binding %q, use one of %s", name, strings.Join(sortedKeys(named), ", "))
+		}
+		setBindingKeys(binding, binding.Help().Desc, keys)
+	}
+	km.describeTop()
+	return nil
+}
+
+// Conflicts describes every key bound to several actions on the same screen,
+// and the search actions that only have keys the search types into the query
+func (km KeyMap) Conflicts() []string {
+	screens := []struct {
+		name     string
+		bindings []key.Binding
+	}{
+		{"history", km.HistoryBindings()},
+		{"search", km.SearchBindings()},
+		{"session picker", km.SessionBindings()},
+		{"error screen", km.ErrorBindings()},
+	}
+
+	var conflicts []string
+	for _, screen := range screens {
+		actions := make(map[string][]string)
+		for _, binding := range screen.bindings {
+			if !binding.Enabled() {
+				continue
+			}
+			for _, k := range binding.Keys() {
+				actions[k] = append(actions[k], binding.Help().Desc)
+			}
+		}
+		for _, k := range sortedKeys(actions) {
+			if len(actions[k]) > 1 {
+				conflicts = append(conflicts, fmt.Sprintf("%s: %q is bound to %s", screen.name, k, strings.Join(actions[k], " and ")))
+			}
+		}
+	}
+
+	for _, binding := range km.SearchBindings() {
+		var typed []string
+		for _, k := range binding.Keys() {
+			if isTypedKey(k) {
+				typed = append(typed, fmt.Sprintf("%q", k))
+			}
+		}
+		if binding.Enabled() && len(typed) > 0 && len(typed) == len(binding.Keys()) {
+			conflicts = append(conflicts, fmt.Sprintf("search: %s is bound to %s, which the search types into the query", binding.Help().Desc, strings.Join(typed, " and ")))
+		}
+	}
+	return conflicts
+}
+
+// isTypedKey reports whether the search types a key into the query before
+// matching it against the bindings, as it does with printable keys
+func isTypedKey(k string) bool {
+	r, size := utf8.DecodeRuneInString(k)
+	return k != "" && size == len(k) && unicode.IsPrint(r) && r != ' '
+}
+
+// withDesc returns a copy of a binding described differently, for actions
+// whose meaning depends on the screen
+func withDesc(binding key.Binding, desc string) key.Binding {
+	binding.SetHelp(binding.Help().Key, desc)
+	return binding
+}
+
+// HistoryBindings returns the bindings of the history view
+func (km KeyMap) HistoryBindings() []key.Binding {
+	return []key.Binding{
+		km.Up, km.Down, km.PageUp, km.PageDown, km.Home, km.End, km.Copy,
+		km.Search, km.RankMode, km.Group, km.Expand, km.Preview, km.Sessions,
+		km.Help, km.historyBack(), km.Quit, km.Top, km.Bottom, km.HalfPageUp,
+		km.HalfPageDown, km.NextMatch, km.PrevMatch, km.Yank,
+	}
+}
+
+// historyBack returns Back as it acts in the history view: it quits, or with
+// Modal clears the search that NextMatch steps through
+func (km KeyMap) historyBack() key.Binding {
+	if km.Modal {
+		return withDesc(km.Back, "clear search")
+	}
+	return withDesc(km.Back, "quit")
+}
+
+// searchConfirm returns the binding that leaves a search for its selected
+// match: Copy copies it, or with Modal Select goes to it in the history
+func (km KeyMap) searchConfirm() key.Binding {
+	if km.Modal {
+		return withDesc(km.Select, "go to match")
+	}
+	return km.Copy
+}
+
+// SearchBindings returns the bindings of the search view. Printable keys are
+// typed into the query instead.
+func (km KeyMap) SearchBindings() []key.Binding {
+	return []key.Binding{
+		km.Up, km.Down, km.PageUp, km.PageDown, km.Home, km.End,
+		km.searchConfirm(), withDesc(km.Back, "exit search"), km.MatchMode,
+		km.RankMode, km.Group, km.Expand, km.Preview, km.Sessions, km.Help,
+		km.Quit,
+	}
+}
+
+// SessionBindings returns the bindings of the session picker
+func (km KeyMap) SessionBindings() []key.Binding {
+	return []key.Binding{km.Up, km.Down, km.Select, km.Back, withDesc(km.Sessions, "back"), km.Quit}
+}
+
+// ErrorBindings returns the bindings of the load error screen
+func (km KeyMap) ErrorBindings() []key.Binding {
+	return []key.Binding{km.Retry, km.EditPath, km.Sessions, withDesc(km.Back, "quit"), km.Quit}
+}
+
+// HistoryShortHelp returns the bindings listed in the footer of the history
+func (km KeyMap) HistoryShortHelp() []key.Binding {
+	if km.Modal {
+		return []key.Binding{km.Up, km.Down, km.Yank, km.Search, km.NextMatch, km.Help, km.Quit}
+	}
+	return []key.Binding{km.Up, km.Down, km.Copy, km.Search, km.Help, km.Quit}
+}
+
+// SearchShortHelp returns the bindings listed in the footer of the search
+func (km KeyMap) SearchShortHelp() []key.Binding {
+	return []key.Binding{km.Up, km.Down, km.searchConfirm(), withDesc(km.Back, "exit search"), km.MatchMode, km.Help, km.Quit}
+}
+
+// FullHelp returns every binding of the history and search views in
+// columns, for the help overlay
+func (km KeyMap) FullHelp() [][]key.Binding {
+	return [][]key.Binding{
+		{km.Up, km.Down, km.PageUp, km.PageDown, km.Home, km.End},
+		{km.Copy, km.Search, km.historyBack(), withDesc(km.Back, "exit search"), km.MatchMode, km.Quit},
+		{km.RankMode, km.Group, km.Expand, km.Preview, km.Sessions, km.Help},
+		{km.Top, km.Bottom, km.HalfPageUp, km.HalfPageDown, km.NextMatch, km.PrevMatch, km.Yank},
+	}
+}
+
+// sortedKeys returns the keys of a map in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestKeyMapConflicts(t *testing.T) {
+	tests := []struct {
+		name      string
+		keymap    KeyMap
+		overrides map[string][]string
+		want      []string
+	}{
+		{name: "default", keymap: DefaultKeyMap()},
+		{name: "vim", keymap: VimKeyMap()},
+		{
+			name:      "two actions on one key",
+			keymap:    DefaultKeyMap(),
+			overrides: map[string][]string{"group": {"ctrl+r"}},
+			want: []string{
+				`history: "ctrl+r" is bound to change order and group`,
+				`search: "ctrl+r" is bound to change order and group`,
+			},
+		},
+		{
+			name:      "search action only on printable keys",
+			keymap:    DefaultKeyMap(),
+			overrides: map[string][]string{"copy": {"y"}, "preview": {"p", "P"}},
+			want: []string{
+				`search: copy is bound to "y", which the search types into the query`,
+				`search: details is bound to "p" and "P", which the search types into the query`,
+			},
+		},
+		{
+			name:      "printable keys next to others",
+			keymap:    DefaultKeyMap(),
+			overrides: map[string][]string{"copy": {"y", "enter"}, "match_mode": {" "}},
+		},
+		{
+			name:      "history only actions",
+			keymap:    DefaultKeyMap(),
+			overrides: map[string][]string{"yank": {"y"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.keymap.ApplyOverrides(tt.overrides); err != nil {
+				t.Fatal(err)
+			}
+			if got := tt.keymap.Conflicts(); !slices.Equal(got, tt.want) {
+				t.Errorf("Conflicts() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
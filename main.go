@@ -1,14 +1,80 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
 )
 
 func main() {
-	logFile, err := os.OpenFile("debug.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	historyFlag := flag.String("history", "", "path of the history file to load (default: discovered from $"+historyPathEnv+", the config file or fish)")
+	shellFlag := flag.String("shell", "", "shell whose history to read: fish, bash or zsh (default: fish)")
+	var archiveFlags sourceSpecs
+	flag.Var(&archiveFlags, "archive", "extra history file to merge in, as [shell:]path[=label] (repeatable)")
+	followFlag := flag.Bool("follow", true, "watch the history file and pick up commands from other shells")
+	debounceFlag := flag.Duration("debounce", 0, "wait this long after the last keystroke before searching, such as 50ms (default: search on every keystroke)")
+	rankFlag := flag.String("rank", "", "order of the history: recency, frecency, frequency or alphabetical (default: recency)")
+	groupFlag := flag.Bool("group", false, "list identical commands once with their run count")
+	themeFlag := flag.String("theme", "", "color theme: auto, dark, light or a theme from the config file (default: auto)")
+	keymapFlag := flag.String("keymap", "", "key bindings: default or vim (default: default)")
+	mouseFlag := flag.Bool("mouse", false, "scroll, select and copy commands with the mouse")
+	heightFlag := flag.Int("height", 0, "draw the interface in at most this many lines under the prompt instead of filling the window")
+	configFlag := flag.String("config", "", "path of the config file (default: $XDG_CONFIG_HOME/bublsrc/config.json)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), `Usage: bublsrc [command] [flags]
+
+Commands:
+  pick       show the history on the terminal and print the chosen command;
+             exits with status 1 when cancelled
+  init fish  print a fish key binding for Ctrl+R that runs pick
+
+Flags:
+`)
+		flag.PrintDefaults()
+	}
+	// A command comes before the flags
+	command, args := "", os.Args[1:]
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		command, args = args[0], args[1:]
+	}
+	flag.CommandLine.Parse(args)
+
+	switch command {
+	case "", "pick":
+	case "init":
+		if err := printInit(flag.Arg(0)); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(2)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+	pick := command == "pick"
+
+	// Picking is bound to a key in the shell, so it must not leave a log in
+	// every directory it is used in
+	logPath := "debug.log"
+	if pick {
+		var err error
+		if logPath, err = DefaultLogPath(); err == nil {
+			err = os.MkdirAll(filepath.Dir(logPath), 0o700)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -18,12 +84,205 @@ func main() {
 
 	logger.Info("Program started")
 
-	app := NewApp(logger)
+	// Picking draws on the terminal, leaving stdout for the chosen command
+	var output io.Writer = os.Stdout
+	var programOptions []tea.ProgramOption
+	if pick {
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			log.Fatalf("pick needs a terminal: %v", err)
+		}
+		defer tty.Close()
+		output = tty
+		lipgloss.SetDefaultRenderer(lipgloss.NewRenderer(tty))
+		programOptions = append(programOptions, tea.WithInput(tty), tea.WithOutput(tty))
+	}
+
+	configPath := *configFlag
+	if configPath == "" {
+		var err error
+		if configPath, err = DefaultConfigPath(); err != nil {
+			logger.Warnf("Could not determine config path: %v", err)
+		}
+	}
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		logger.Errorf("Failed to load config: %v", err)
+	}
+
+	shell := *shellFlag
+	if shell == "" {
+		shell = config.Shell
+	}
+	if shell == "" {
+		shell = ShellFish
+	}
+
+	discovery := NewHistoryDiscoveryService(logger)
+	historyPath, err := discovery.ResolveHistoryPath(shell, *historyFlag, config.HistoryPath)
+	if err != nil {
+		logger.Errorf("Failed to resolve history path: %v", err)
+	}
+
+	source, err := NewHistorySource(shell, historyPath, hostLabel())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	archiveConfigs := config.Archives
+	for _, spec := range archiveFlags {
+		archive, err := ParseSourceSpec(spec, shell)
+		if err != nil {
+			log.Fatal(err)
+		}
+		archiveConfigs = append(archiveConfigs, archive)
+	}
+	var archives []HistorySource
+	for _, archive := range archiveConfigs {
+		if archive.Shell == "" {
+			archive.Shell = shell
+		}
+		archiveSource, err := NewHistorySource(archive.Shell, archive.Path, archive.Label)
+		if err != nil {
+			log.Fatal(err)
+		}
+		archives = append(archives, archiveSource)
+	}
+
+	follow := *followFlag
+	if config.Follow != nil && !isFlagSet("follow") {
+		follow = *config.Follow
+	}
+
+	debounce := *debounceFlag
+	if config.SearchDebounceMS > 0 && !isFlagSet("debounce") {
+		debounce = time.Duration(config.SearchDebounceMS) * time.Millisecond
+	}
+
+	rankName := *rankFlag
+	if rankName == "" {
+		rankName = config.Rank
+	}
+	rank := RankRecency
+	if rankName != "" {
+		if rank, err = ParseRankMode(rankName); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	group := config.Group
+	if isFlagSet("group") {
+		group = *groupFlag
+	}
+
+	height := config.Height
+	if isFlagSet("height") {
+		height = *heightFlag
+	}
+	if height < 0 {
+		log.Fatalf("height must not be negative, got %d", height)
+	}
+	if pick && height == 0 {
+		// A full screen picker gives the screen back as it was
+		programOptions = append(programOptions, tea.WithAltScreen())
+	}
+
+	themeName := *themeFlag
+	if themeName == "" {
+		themeName = config.Theme
+	}
+	if themeName == "" {
+		themeName = "auto"
+	}
+	theme, err := ResolveTheme(themeName, config.Themes)
+	if err != nil {
+		log.Fatal(err)
+	}
+	useNoColorProfile(output)
+	applyTheme(theme)
+	logger.Infof("Using theme %q", themeName)
+
+	keymapName := *keymapFlag
+	if keymapName == "" {
+		keymapName = config.Keymap
+	}
+	keys, err := KeyMapNamed(keymapName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := keys.ApplyOverrides(config.Keys); err != nil {
+		log.Fatal(err)
+	}
+	for _, conflict := range keys.Conflicts() {
+		logger.Warnf("Key binding conflict: %s", conflict)
+		fmt.Fprintf(os.Stderr, "warning: key binding conflict: %s\n", conflict)
+	}
 
-	if _, err := tea.NewProgram(app).Run(); err != nil {
+	app := NewApp(logger, discovery, source, archives, AppOptions{
+		Follow:              follow,
+		Debounce:            debounce,
+		Rank:                rank,
+		Group:               group,
+		NormalizeWhitespace: config.NormalizeWhitespace,
+		Keys:                keys,
+		Pick:                pick,
+		Height:              height,
+	})
+
+	mouse := config.Mouse
+	if isFlagSet("mouse") {
+		mouse = *mouseFlag
+	}
+	if mouse {
+		programOptions = append(programOptions, tea.WithMouseCellMotion())
+	}
+
+	model, err := tea.NewProgram(app, programOptions...).Run()
+	if err != nil {
 		logger.Errorf("Error running program: %v", err)
 		os.Exit(1)
 	}
 
 	logger.Info("Program ended")
+
+	if pick {
+		final, _ := model.(Model)
+		if final.Picked() == "" {
+			logger.Info("Nothing picked")
+			os.Exit(1)
+		}
+		fmt.Println(final.Picked())
+	}
+}
+
+// sourceSpecs collects repeated -archive flags
+type sourceSpecs []string
+
+func (s *sourceSpecs) String() string {
+	return strings.Join(*s, ", ")
+}
+
+func (s *sourceSpecs) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// isFlagSet reports whether a flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// hostLabel names the origin of the local history
+func hostLabel() string {
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		return "local"
+	}
+	return host
 }
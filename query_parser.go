@@ -0,0 +1,222 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TermKind tells what part of a command a query term matches
+type TermKind int
+
+const (
+	// TermText matches anywhere in the command
+	TermText TermKind = iota
+	// TermPhrase matches a quoted phrase exactly, even in fuzzy mode
+	TermPhrase
+	// TermCmd matches the first token of the command
+	TermCmd
+	// TermPath matches one of the command's paths
+	TermPath
+	// TermOrigin matches one of the sources the command came from
+	TermOrigin
+	// TermAfter matches commands run on or after a date
+	TermAfter
+	// TermBefore matches commands run before a date
+	TermBefore
+)
+
+// qualifierKinds maps the qualifier names a query may use to their kinds
+var qualifierKinds = map[string]TermKind{
+	"cmd":    TermCmd,
+	"path":   TermPath,
+	"origin": TermOrigin,
+	"after":  TermAfter,
+	"before": TermBefore,
+}
+
+// queryDateLayouts are the formats accepted by after: and before:
+var queryDateLayouts = []string{"2006-01-02", "2006-01-02T15:04", time.RFC3339}
+
+// QueryTerm is a single condition of a query
+type QueryTerm struct {
+	Kind    TermKind
+	Value   string
+	Time    time.Time
+	Negated bool
+}
+
+// Query is a parsed search query: every clause must match, and a clause
+// matches when any of its terms does.
+//
+// The grammar is small: space separated terms are ANDed, OR between two terms
+// makes them alternatives, a leading - (or NOT before a term) negates it,
+// "double quotes" group a phrase and name:value qualifiers (cmd:, path:,
+// origin:, after:, before:) restrict what a term looks at. A backslash takes
+// the next character literally, so flags are searched for as "-m" or \-m.
+type Query struct {
+	Clauses [][]QueryTerm
+}
+
+// IsEmpty reports whether the query has no conditions
+func (q Query) IsEmpty() bool {
+	return len(q.Clauses) == 0
+}
+
+// ParseQuery parses a search query
+func ParseQuery(input string) (Query, error) {
+	tokens, err := tokenizeQuery(input)
+	if err != nil {
+		return Query{}, err
+	}
+
+	var query Query
+	pendingOr, pendingNot := false, false
+	for _, token := range tokens {
+		switch {
+		case token.isOr:
+			if len(query.Clauses) == 0 || pendingOr || pendingNot {
+				return Query{}, fmt.Errorf("OR needs a term on both sides")
+			}
+			pendingOr = true
+			continue
+		case token.isNot:
+			if pendingNot {
+				return Query{}, fmt.Errorf("NOT needs a term after it")
+			}
+			pendingNot = true
+			continue
+		}
+
+		term, err := parseTerm(token)
+		if err != nil {
+			return Query{}, err
+		}
+		term.Negated = pendingNot || token.negated
+		pendingNot = false
+		if pendingOr {
+			last := len(query.Clauses) - 1
+			query.Clauses[last] = append(query.Clauses[last], term)
+			pendingOr = false
+		} else {
+			query.Clauses = append(query.Clauses, []QueryTerm{term})
+		}
+	}
+	if pendingOr {
+		return Query{}, fmt.Errorf("OR needs a term on both sides")
+	}
+	if pendingNot {
+		return Query{}, fmt.Errorf("NOT needs a term after it")
+	}
+	return query, nil
+}
+
+// queryToken is a lexical token of a query
+type queryToken struct {
+	text      string
+	qualifier string
+	quoted    bool
+	negated   bool
+	isOr      bool
+	isNot     bool
+}
+
+// tokenizeQuery splits a query into terms, honouring quotes
+func tokenizeQuery(input string) ([]queryToken, error) {
+	runes := []rune(input)
+	var tokens []queryToken
+
+	for i := 0; i < len(runes); {
+		if unicode.IsSpace(runes[i]) {
+			i++
+			continue
+		}
+
+		var token queryToken
+		// A dash negates the term it starts; a lone one is searched for
+		if runes[i] == '-' && i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			token.negated = true
+			i++
+		}
+
+		// A bare word, possibly a qualifier name followed by its value
+		var chars []rune
+		escaped := false
+		for i < len(runes) && !unicode.IsSpace(runes[i]) && runes[i] != '"' {
+			if runes[i] == '\\' && i+1 < len(runes) {
+				escaped = true
+				i++
+			}
+			chars = append(chars, runes[i])
+			i++
+		}
+		word := string(chars)
+		if name, value, found := strings.Cut(word, ":"); found && !escaped {
+			if _, ok := qualifierKinds[strings.ToLower(name)]; ok {
+				token.qualifier = strings.ToLower(name)
+				word = value
+			}
+		}
+
+		if i < len(runes) && runes[i] == '"' && word == "" {
+			end := i + 1
+			for end < len(runes) && runes[end] != '"' {
+				end++
+			}
+			if end >= len(runes) {
+				return nil, fmt.Errorf("unterminated quote at position %d", i+1)
+			}
+			word = string(runes[i+1 : end])
+			token.quoted = true
+			i = end + 1
+		} else if i < len(runes) && runes[i] == '"' {
+			return nil, fmt.Errorf("unexpected quote at position %d", i+1)
+		}
+
+		token.text = word
+		keyword := !token.quoted && !token.negated && !escaped && token.qualifier == ""
+		token.isOr = keyword && word == "OR"
+		token.isNot = keyword && word == "NOT"
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
+
+// parseTerm turns a token into a term
+func parseTerm(token queryToken) (QueryTerm, error) {
+	term := QueryTerm{Kind: TermText, Value: strings.ToLower(token.text)}
+	if token.quoted {
+		term.Kind = TermPhrase
+	}
+
+	if token.qualifier == "" {
+		if term.Value == "" {
+			return term, fmt.Errorf("empty phrase")
+		}
+		return term, nil
+	}
+
+	term.Kind = qualifierKinds[token.qualifier]
+	if token.text == "" {
+		return term, fmt.Errorf("%s: needs a value", token.qualifier)
+	}
+	if term.Kind == TermAfter || term.Kind == TermBefore {
+		when, err := parseQueryDate(token.text)
+		if err != nil {
+			return term, fmt.Errorf("%s: %w", token.qualifier, err)
+		}
+		term.Time = when
+	}
+	return term, nil
+}
+
+// parseQueryDate parses the date of an after: or before: qualifier in local time
+func parseQueryDate(value string) (time.Time, error) {
+	for _, layout := range queryDateLayouts {
+		if when, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return when, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid date %q, use YYYY-MM-DD", value)
+}
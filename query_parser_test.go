@@ -0,0 +1,107 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseQuery(t *testing.T) {
+	text := func(value string) QueryTerm { return QueryTerm{Kind: TermText, Value: value} }
+	not := func(term QueryTerm) QueryTerm { term.Negated = true; return term }
+
+	tests := []struct {
+		input string
+		want  [][]QueryTerm
+	}{
+		{input: "", want: nil},
+		{input: "Git  Status", want: [][]QueryTerm{{text("git")}, {text("status")}}},
+		{input: "git -push", want: [][]QueryTerm{{text("git")}, {not(text("push"))}}},
+		{input: `docker -"docker ps"`, want: [][]QueryTerm{{text("docker")}, {not(QueryTerm{Kind: TermPhrase, Value: "docker ps"})}}},
+		{input: "-cmd:git", want: [][]QueryTerm{{not(QueryTerm{Kind: TermCmd, Value: "git"})}}},
+		// Flags are searched for when quoted or escaped; a lone dash is a term
+		{input: `git commit "-m" \-a`, want: [][]QueryTerm{{text("git")}, {text("commit")}, {{Kind: TermPhrase, Value: "-m"}}, {text("-a")}}},
+		{input: "ls --all -", want: [][]QueryTerm{{text("ls")}, {not(text("-all"))}, {text("-")}}},
+		{input: `say\ hi \OR \"`, want: [][]QueryTerm{{text("say hi")}, {text("or")}, {text(`"`)}}},
+		// NOT is an alias of the dash
+		{input: "git NOT push", want: [][]QueryTerm{{text("git")}, {not(text("push"))}}},
+		{input: `NOT "docker ps"`, want: [][]QueryTerm{{not(QueryTerm{Kind: TermPhrase, Value: "docker ps"})}}},
+		{input: "NOT cmd:git", want: [][]QueryTerm{{not(QueryTerm{Kind: TermCmd, Value: "git"})}}},
+		{input: "make OR NOT ninja", want: [][]QueryTerm{{text("make"), not(text("ninja"))}}},
+		{input: "make OR -ninja", want: [][]QueryTerm{{text("make"), not(text("ninja"))}}},
+		{input: "-OR", want: [][]QueryTerm{{not(text("or"))}}},
+		{input: "a OR b c", want: [][]QueryTerm{{text("a"), text("b")}, {text("c")}}},
+		// Lower case keywords and quoted ones are plain terms
+		{input: `not or "OR"`, want: [][]QueryTerm{{text("not")}, {text("or")}, {{Kind: TermPhrase, Value: "or"}}}},
+		{input: "path:README origin:Laptop", want: [][]QueryTerm{{{Kind: TermPath, Value: "readme"}}, {{Kind: TermOrigin, Value: "laptop"}}}},
+		{input: "http://host:80", want: [][]QueryTerm{{text("http://host:80")}}},
+		{input: "after:2025-01-02", want: [][]QueryTerm{{{Kind: TermAfter, Value: "2025-01-02", Time: time.Date(2025, 1, 2, 0, 0, 0, 0, time.Local)}}}},
+	}
+	for _, tt := range tests {
+		query, err := ParseQuery(tt.input)
+		if err != nil {
+			t.Errorf("ParseQuery(%q): %v", tt.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(query.Clauses, tt.want) {
+			t.Errorf("ParseQuery(%q) = %+v, want %+v", tt.input, query.Clauses, tt.want)
+		}
+	}
+}
+
+func TestParseQueryErrors(t *testing.T) {
+	for _, input := range []string{
+		"OR git",
+		"git OR",
+		"git OR OR ls",
+		"git NOT",
+		"NOT NOT git",
+		"NOT OR git",
+		`"unterminated`,
+		`a"b"`,
+		`-"`,
+		"-cmd:",
+		`""`,
+		"cmd:",
+		"after:yesterday",
+	} {
+		if _, err := ParseQuery(input); err == nil {
+			t.Errorf("ParseQuery(%q) succeeded, want an error", input)
+		}
+	}
+}
+
+func TestSearchFlagsAndNegation(t *testing.T) {
+	commands := []FishCommand{
+		{Command: "git commit -m fix"},
+		{Command: "git commit --amend"},
+		{Command: "git push"},
+		{Command: "docker ps -a"},
+		{Command: "docker build ."},
+	}
+	tests := []struct {
+		query string
+		want  []string
+	}{
+		{query: `git commit "-m"`, want: []string{"git commit -m fix"}},
+		{query: `\--amend`, want: []string{"git commit --amend"}},
+		{query: "git -commit", want: []string{"git push"}},
+		{query: "git NOT commit", want: []string{"git push"}},
+		{query: `commit -"-m"`, want: []string{"git commit --amend"}},
+		{query: `commit -\-m`, want: []string{"git commit --amend"}},
+		{query: `docker -"docker ps"`, want: []string{"docker build ."}},
+	}
+	for _, tt := range tests {
+		outcome, err := searchRequest{index: NewSearchIndex(), commands: commands, query: tt.query, stop: make(chan struct{})}.run()
+		if err != nil {
+			t.Fatalf("search %q: %v", tt.query, err)
+		}
+		var got []string
+		for i := range outcome.hits {
+			got = append(got, outcome.result(i).Command)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("search %q = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,395 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+)
+
+// SearchIndex narrows down which history entries can match a query so that a
+// keystroke does not have to look at every command.
+//
+// Every distinct command text is a document. A document has trigram postings
+// for its lower case text and for its paths, and a bitmask of the characters
+// it contains, which still filters queries too short for trigrams and fuzzy
+// terms whose characters need not be adjacent. Its text is also kept
+// prepared for fuzzy matching, once a fuzzy search needs it. Documents
+// outlive history updates, so syncing a grown history only indexes the new
+// commands.
+//
+// Searches run in the background, so the index serializes them.
+type SearchIndex struct {
+	mu        sync.Mutex
+	docs      map[string]int32
+	lower     []string
+	fuzzy     []fuzzyText
+	masks     []uint64
+	paths     [][]string
+	grams     map[uint32][]int32
+	pathGrams map[uint32][]int32
+	// matches lets a search remember how each document matched
+	matches []docMatch
+	// history is the last synced history; rows holds the document of each of
+	// its entries and docRows the entries of each document, the ones of
+	// document d being docRows[docStart[d]:docStart[d+1]]
+	history  []FishCommand
+	rows     []int32
+	docStart []int32
+	docRows  []int32
+}
+
+// NewSearchIndex creates an empty index
+func NewSearchIndex() *SearchIndex {
+	return &SearchIndex{
+		docs:      make(map[string]int32),
+		grams:     make(map[uint32][]int32),
+		pathGrams: make(map[uint32][]int32),
+	}
+}
+
+// syncHistory points the index at a new snapshot of the history, indexing
+// the commands it has not seen yet
+func (ix *SearchIndex) syncHistory(commands []FishCommand) {
+	if sameHistory(commands, ix.history) {
+		return
+	}
+
+	ix.history = commands
+	ix.rows = slices.Grow(ix.rows[:0], len(commands))[:len(commands)]
+	for i, cmd := range commands {
+		doc, ok := ix.docs[cmd.Command]
+		if !ok {
+			doc = ix.addDoc(cmd.Command)
+		}
+		if len(cmd.Paths) > 0 {
+			ix.addPaths(doc, cmd.Paths)
+		}
+		ix.rows[i] = doc
+	}
+
+	// Group the entries by document with a counting sort
+	ix.docStart = slices.Grow(ix.docStart[:0], len(ix.lower)+1)[:len(ix.lower)+1]
+	clear(ix.docStart)
+	for _, doc := range ix.rows {
+		ix.docStart[doc+1]++
+	}
+	for d := 1; d < len(ix.docStart); d++ {
+		ix.docStart[d] += ix.docStart[d-1]
+	}
+	ix.docRows = slices.Grow(ix.docRows[:0], len(ix.rows))[:len(ix.rows)]
+	next := slices.Clone(ix.docStart)
+	for row, doc := range ix.rows {
+		ix.docRows[next[doc]] = int32(row)
+		next[doc]++
+	}
+}
+
+// docText is the text of a document as the matchers use it: in lower case
+// and, for fuzzy searches, prepared for fuzzy matching. match starts out
+// unchecked for every search.
+type docText struct {
+	lower string
+	fuzzy *fuzzyText
+	match *docMatch
+}
+
+// docMatch is how a document matched a search, for searches whose outcome
+// only depends on the command text
+type docMatch struct {
+	checked bool
+	ok      bool
+	score   int32
+}
+
+// newDocText prepares a command that is not in the index
+func newDocText(command string) docText {
+	fuzzy := newFuzzyText(command)
+	return docText{lower: strings.ToLower(command), fuzzy: &fuzzy, match: &docMatch{}}
+}
+
+// Each calls visit, in history order, for every entry of commands that may
+// match query, passing its text, until visit returns false. When within is
+// not nil only those entries, in ascending order, are considered.
+func (ix *SearchIndex) Each(commands []FishCommand, query Query, fuzzy bool, within []int32, visit func(row int32, text docText) bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+	ix.syncHistory(commands)
+
+	// Refining fewer entries than there are documents checks them directly,
+	// which costs less than merging postings
+	required := requiredMask(query)
+	docs, all := []int32(nil), true
+	if within == nil || len(within) > len(ix.lower) {
+		docs, all = ix.queryDocs(query, fuzzy)
+	}
+
+	rows := within
+	switch {
+	case within != nil && !all:
+		candidates := make([]uint64, (len(ix.lower)+63)/64)
+		for _, doc := range docs {
+			candidates[doc/64] |= 1 << (doc % 64)
+		}
+		rows = slices.DeleteFunc(slices.Clone(within), func(row int32) bool {
+			doc := ix.rows[row]
+			return candidates[doc/64]&(1<<(doc%64)) == 0
+		})
+	case !all:
+		count := 0
+		for _, doc := range docs {
+			count += int(ix.docStart[doc+1] - ix.docStart[doc])
+		}
+		rows = make([]int32, 0, count)
+		for _, doc := range docs {
+			rows = append(rows, ix.docRows[ix.docStart[doc]:ix.docStart[doc+1]]...)
+		}
+		slices.Sort(rows)
+	}
+
+	ix.matches = slices.Grow(ix.matches[:0], len(ix.lower))[:len(ix.lower)]
+	clear(ix.matches)
+	text := func(row, doc int32) docText {
+		if !fuzzy {
+			return docText{lower: ix.lower[doc], match: &ix.matches[doc]}
+		}
+		if ix.fuzzy[doc].lower == nil {
+			ix.fuzzy[doc] = newFuzzyText(commands[row].Command)
+		}
+		return docText{lower: ix.lower[doc], fuzzy: &ix.fuzzy[doc], match: &ix.matches[doc]}
+	}
+
+	if rows == nil && all {
+		for row, doc := range ix.rows {
+			if ix.masks[doc]&required == required && !visit(int32(row), text(int32(row), doc)) {
+				return
+			}
+		}
+		return
+	}
+	for _, row := range rows {
+		doc := ix.rows[row]
+		if ix.masks[doc]&required == required && !visit(row, text(row, doc)) {
+			return
+		}
+	}
+}
+
+// addDoc indexes a command text not seen before
+func (ix *SearchIndex) addDoc(command string) int32 {
+	doc := int32(len(ix.lower))
+	lower := strings.ToLower(command)
+	ix.docs[command] = doc
+	ix.lower = append(ix.lower, lower)
+	ix.fuzzy = append(ix.fuzzy, fuzzyText{})
+	ix.masks = append(ix.masks, charMask(lower))
+	ix.paths = append(ix.paths, nil)
+
+	// doc is the highest document so far, so appending keeps postings sorted
+	eachTrigram(lower, func(gram uint32) {
+		postings := ix.grams[gram]
+		if len(postings) == 0 || postings[len(postings)-1] != doc {
+			ix.grams[gram] = append(postings, doc)
+		}
+	})
+	return doc
+}
+
+// addPaths indexes the paths of a document it does not have yet. Merged
+// entries can gain paths later, so postings are inserted in order.
+func (ix *SearchIndex) addPaths(doc int32, paths []string) {
+	for _, path := range paths {
+		if slices.Contains(ix.paths[doc], path) {
+			continue
+		}
+		ix.paths[doc] = append(ix.paths[doc], path)
+		eachTrigram(strings.ToLower(path), func(gram uint32) {
+			postings := ix.pathGrams[gram]
+			if i, found := slices.BinarySearch(postings, doc); !found {
+				ix.pathGrams[gram] = slices.Insert(postings, i, doc)
+			}
+		})
+	}
+}
+
+// queryDocs returns the sorted documents that may match query, or false when
+// the index cannot narrow it down
+func (ix *SearchIndex) queryDocs(query Query, fuzzy bool) ([]int32, bool) {
+	var docs []int32
+	all := true
+	for _, clause := range query.Clauses {
+		clauseDocs, clauseAll := ix.clauseDocs(clause, fuzzy)
+		switch {
+		case clauseAll:
+		case all:
+			docs, all = clauseDocs, false
+		default:
+			docs = intersectPostings(docs, clauseDocs)
+		}
+	}
+	return docs, all
+}
+
+// clauseDocs returns the documents that may match any term of a clause
+func (ix *SearchIndex) clauseDocs(clause []QueryTerm, fuzzy bool) ([]int32, bool) {
+	var docs []int32
+	for _, term := range clause {
+		termDocs, all := ix.termDocs(term, fuzzy)
+		if all {
+			return nil, true
+		}
+		docs = unionPostings(docs, termDocs)
+	}
+	return docs, false
+}
+
+// termDocs returns the documents that may match a term. Negated terms and
+// terms without trigrams do not narrow anything down.
+func (ix *SearchIndex) termDocs(term QueryTerm, fuzzy bool) ([]int32, bool) {
+	if term.Negated {
+		return nil, true
+	}
+	switch term.Kind {
+	case TermText:
+		if fuzzy {
+			return nil, true
+		}
+		return gramDocs(ix.grams, term.Value)
+	case TermPhrase, TermCmd:
+		return gramDocs(ix.grams, term.Value)
+	case TermPath:
+		return gramDocs(ix.pathGrams, term.Value)
+	}
+	return nil, true
+}
+
+// gramDocs returns the documents holding every trigram of value
+func gramDocs(grams map[uint32][]int32, value string) ([]int32, bool) {
+	if len(value) < 3 {
+		return nil, true
+	}
+
+	var lists [][]int32
+	missing := false
+	eachTrigram(value, func(gram uint32) {
+		postings := grams[gram]
+		if len(postings) == 0 {
+			missing = true
+		}
+		lists = append(lists, postings)
+	})
+	if missing {
+		return nil, false
+	}
+
+	// Start from the rarest trigram to keep the intersections small
+	slices.SortFunc(lists, func(a, b []int32) int {
+		return len(a) - len(b)
+	})
+	docs := lists[0]
+	for _, postings := range lists[1:] {
+		docs = intersectPostings(docs, postings)
+	}
+	return docs, false
+}
+
+// requiredMask returns the characters every match of query must contain,
+// taken from the clauses made of a single positive term
+func requiredMask(query Query) uint64 {
+	var mask uint64
+	for _, clause := range query.Clauses {
+		if len(clause) != 1 || clause[0].Negated {
+			continue
+		}
+		switch clause[0].Kind {
+		case TermText, TermPhrase, TermCmd:
+			mask |= charMask(clause[0].Value)
+		}
+	}
+	return mask
+}
+
+// charMask returns a bitmask of the characters of s. Letters and digits have
+// a bit each, anything else shares the remaining bits.
+func charMask(s string) uint64 {
+	var mask uint64
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z':
+			mask |= 1 << (r - 'a')
+		case r >= '0' && r <= '9':
+			mask |= 1 << (26 + r - '0')
+		default:
+			mask |= 1 << (36 + uint(r)%28)
+		}
+	}
+	return mask
+}
+
+// eachTrigram calls fn for every three byte sequence of s
+func eachTrigram(s string, fn func(gram uint32)) {
+	for i := 0; i+3 <= len(s); i++ {
+		fn(uint32(s[i])<<16 | uint32(s[i+1])<<8 | uint32(s[i+2]))
+	}
+}
+
+// intersectPostings returns the documents in both sorted lists. A list much
+// shorter than the other is looked up in it rather than merged with it.
+func intersectPostings(a, b []int32) []int32 {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	docs := make([]int32, 0, len(a))
+	if len(a)*16 < len(b) {
+		for _, doc := range a {
+			i, found := slices.BinarySearch(b, doc)
+			if found {
+				docs = append(docs, doc)
+			}
+			b = b[i:]
+		}
+		return docs
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			docs = append(docs, a[i])
+			i++
+			j++
+		}
+	}
+	return docs
+}
+
+// unionPostings returns the documents in either sorted list
+func unionPostings(a, b []int32) []int32 {
+	docs := make([]int32, 0, len(a)+len(b))
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			docs = append(docs, a[i])
+			i++
+		case a[i] > b[j]:
+			docs = append(docs, b[j])
+			j++
+		default:
+			docs = append(docs, a[i])
+			i++
+			j++
+		}
+	}
+	docs = append(docs, a[i:]...)
+	return append(docs, b[j:]...)
+}
+
+// sameHistory reports whether two history snapshots are the same slice
+func sameHistory(a, b []FishCommand) bool {
+	if len(a) != len(b) || a == nil || b == nil {
+		return false
+	}
+	return len(a) == 0 || &a[0] == &b[0]
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+var indexHistory = []FishCommand{
+	{Command: "git status"},
+	{Command: "git stash pop"},
+	{Command: "ls -la"},
+	{Command: "make build"},
+	{Command: "go test ./..."},
+	{Command: "vim README.md", Paths: []string{"README.md"}},
+	{Command: "git status"},
+}
+
+// candidates returns the rows the index lets through for a query
+func candidates(t *testing.T, ix *SearchIndex, input string, fuzzy bool, within []int32) []int32 {
+	t.Helper()
+	query, err := ParseQuery(input)
+	if err != nil {
+		t.Fatalf("ParseQuery(%q): %v", input, err)
+	}
+	var rows []int32
+	ix.Each(indexHistory, query, fuzzy, within, func(row int32, text docText) bool {
+		rows = append(rows, row)
+		return true
+	})
+	return rows
+}
+
+func TestSearchIndexCandidates(t *testing.T) {
+	tests := []struct {
+		query string
+		fuzzy bool
+		want  []int32
+	}{
+		{query: "status", want: []int32{0, 6}},
+		{query: "git sta", want: []int32{0, 1, 6}},
+		{query: "STASH", want: []int32{1}},
+		{query: "make OR vim", want: []int32{3, 5}},
+		{query: `"go test"`, want: []int32{4}},
+		{query: "path:readme", want: []int32{5}},
+		{query: "cmd:git", want: []int32{0, 1, 6}},
+		{query: "zzz", want: nil},
+		// Too short for trigrams, the character mask still filters
+		{query: "gi", want: []int32{0, 1, 6}},
+		// Fuzzy terms are not contiguous, so only the mask applies
+		{query: "gst", fuzzy: true, want: []int32{0, 1, 4, 6}},
+	}
+	ix := NewSearchIndex()
+	for _, tt := range tests {
+		if got := candidates(t, ix, tt.query, tt.fuzzy, nil); !slices.Equal(got, tt.want) {
+			t.Errorf("candidates(%q, fuzzy=%v) = %v, want %v", tt.query, tt.fuzzy, got, tt.want)
+		}
+	}
+}
+
+func TestSearchIndexWithin(t *testing.T) {
+	ix := NewSearchIndex()
+	if got, want := candidates(t, ix, "git", false, []int32{1, 2, 6}), []int32{1, 6}; !slices.Equal(got, want) {
+		t.Errorf("candidates within [1 2 6] = %v, want %v", got, want)
+	}
+}
+
+func TestSearchRefinesPreviousResults(t *testing.T) {
+	tests := []struct {
+		previous, next         string
+		previousMode, nextMode MatchMode
+		refined                bool
+	}{
+		{previous: "gi", next: "git", refined: true},
+		{previous: "git", next: "git sta", refined: true},
+		{previous: "git", next: "ls", refined: false},
+		{previous: "git", next: "git OR ls", refined: false},
+		{previous: "git", next: "git", nextMode: MatchFuzzy, refined: false},
+		{previous: "gt", next: "gts", previousMode: MatchFuzzy, nextMode: MatchFuzzy, refined: true},
+	}
+	for _, tt := range tests {
+		ix := NewSearchIndex()
+		previous, err := searchRequest{index: ix, commands: indexHistory, query: tt.previous, mode: tt.previousMode, stop: make(chan struct{})}.run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		request := searchRequest{index: ix, commands: indexHistory, query: tt.next, mode: tt.nextMode, previous: previous, stop: make(chan struct{})}
+		next, err := request.run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refined := request.refinableRows(next) != nil; refined != tt.refined {
+			t.Errorf("%q then %q: refined = %v, want %v", tt.previous, tt.next, refined, tt.refined)
+		}
+
+		// Refining finds what a search from scratch finds
+		fresh, err := searchRequest{index: NewSearchIndex(), commands: indexHistory, query: tt.next, mode: tt.nextMode, stop: make(chan struct{})}.run()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(next.hits, fresh.hits) {
+			t.Errorf("%q then %q: hits %v, want %v", tt.previous, tt.next, next.hits, fresh.hits)
+		}
+	}
+}
+
+func TestSortByScore(t *testing.T) {
+	tests := []struct {
+		name string
+		hits []searchHit
+		want []searchHit
+	}{
+		{
+			name: "narrow scores",
+			hits: []searchHit{{row: 0, score: 2}, {row: 1, score: 5}, {row: 2, score: 2}, {row: 3, score: 5}},
+			want: []searchHit{{row: 1, score: 5}, {row: 3, score: 5}, {row: 0, score: 2}, {row: 2, score: 2}},
+		},
+		{
+			name: "spread scores",
+			hits: []searchHit{{row: 0, score: 1}, {row: 1, score: 900}, {row: 2, score: 1}},
+			want: []searchHit{{row: 1, score: 900}, {row: 0, score: 1}, {row: 2, score: 1}},
+		},
+	}
+	for _, tt := range tests {
+		if got := sortByScore(slices.Clone(tt.hits)); !slices.Equal(got, tt.want) {
+			t.Errorf("%s: sortByScore = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// syntheticHistory returns n commands in the shape of a real history: a few
+// hundred programs with varied arguments, many of them repeated
+func syntheticHistory(n int) []FishCommand {
+	programs := []string{"git", "kubectl", "docker", "go", "make", "ls", "cd", "vim", "ssh", "curl", "rg", "terraform"}
+	verbs := []string{"status", "get pods", "run --rm", "test ./...", "build", "-la", "apply", "logs -f", "describe", "push origin"}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	commands := make([]FishCommand, n)
+	for i := range commands {
+		commands[i] = FishCommand{
+			Command: fmt.Sprintf("%s %s %s-%d", programs[i%len(programs)], verbs[i*7%len(verbs)], "service", i%5000),
+			When:    start.Add(time.Duration(n-i) * time.Second),
+		}
+	}
+	return commands
+}
+
+// BenchmarkSearchIndex types a query one key at a time over a large history,
+// each keystroke refining the results of the one before. ns/key is the
+// average cost of a keystroke, to hold against the goal of a millisecond.
+func BenchmarkSearchIndex(b *testing.B) {
+	commands := syntheticHistory(200_000)
+	ix := NewSearchIndex()
+	ix.Each(commands, Query{}, false, nil, func(int32, docText) bool { return false })
+
+	const typed = "kubectl logs service-42"
+	for _, mode := range []MatchMode{MatchSubstring, MatchFuzzy} {
+		b.Run(mode.String(), func(b *testing.B) {
+			keys := 0
+			for b.Loop() {
+				var previous searchOutcome
+				for end := 1; end <= len(typed); end++ {
+					outcome, err := searchRequest{index: ix, commands: commands, query: typed[:end], mode: mode, previous: previous, stop: make(chan struct{})}.run()
+					if err != nil {
+						b.Fatal(err)
+					}
+					previous = outcome
+					keys++
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(keys), "ns/key")
+		})
+	}
+}
@@ -1,73 +1,342 @@
 package main
 
 import (
+	"errors"
+	"iter"
+	"math/bits"
+	"regexp"
+	"regexp/syntax"
+	"slices"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// MatchMode selects how the query is matched against commands
+type MatchMode int
+
+const (
+	// MatchSubstring finds commands containing the query text
+	MatchSubstring MatchMode = iota
+	// MatchFuzzy finds commands containing the query's characters in order
+	// and ranks them by how well they match
+	MatchFuzzy
+	// MatchRegex treats the query as a Go regular expression
+	MatchRegex
 )
 
+// regexPrefix switches a single query to regex matching whatever the mode
+const regexPrefix = "re:"
+
+// searchPendingDelay is how long a search runs before the view says so, which
+// keeps the indicator from flashing on every keystroke
+const searchPendingDelay = 150 * time.Millisecond
+
+// searchStopInterval is how many commands a search checks between looking
+// for a newer search that supersedes it
+const searchStopInterval = 1024
+
+// errSearchStopped aborts a search that has been superseded
+var errSearchStopped = errors.New("search stopped")
+
+// String returns the name shown for the mode
+func (m MatchMode) String() string {
+	switch m {
+	case MatchFuzzy:
+		return "fuzzy"
+	case MatchRegex:
+		return "regex"
+	}
+	return "substring"
+}
+
+// SearchResult is a command matching the current query
+type SearchResult struct {
+	FishCommand
+	// Score ranks fuzzy matches; higher is better
+	Score int
+	// Matches holds the rune positions of Command that matched the query
+	Matches []int
+}
+
+// searchHit is a matching entry of the searched history
+type searchHit struct {
+	row   int32
+	score int32
+}
+
+// searchOutcome is what a query found. Only the history positions and scores
+// of the matches are kept; a SearchResult with the positions to highlight is
+// built on demand for the few results on screen.
+type searchOutcome struct {
+	history []FishCommand
+	query   string
+	mode    MatchMode
+	parsed  Query
+	regex   *regexp.Regexp
+	hits    []searchHit
+}
+
+// searchResultsMsg delivers the outcome of a search started by the
+// SearchService, tagged with the generation of the search
+type searchResultsMsg struct {
+	generation uint64
+	outcome    searchOutcome
+	err        error
+}
+
+// searchPendingMsg redraws the view once a search has been running for
+// searchPendingDelay
+type searchPendingMsg struct{}
+
+// searchRequest is a search to run off the Update loop. It holds everything
+// the search reads, so the service can take new input while it runs.
+type searchRequest struct {
+	index    *SearchIndex
+	commands []FishCommand
+	query    string
+	mode     MatchMode
+	// recencyBonus favours recent fuzzy matches; without it, ties keep the
+	// order of commands
+	recencyBonus bool
+	// previous is the outcome shown when the search started
+	previous searchOutcome
+	stop     chan struct{}
+}
+
 // SearchService handles all search-related operations
 type SearchService struct {
 	logger *LoggerService
 	// Internal search state
-	query   string
-	results []FishCommand
-	index   int
+	query     string
+	matchMode MatchMode
+	rankMode  RankMode
+	outcome   searchOutcome
+	index     int
+	// queryErr holds why the current query is invalid; the results of the
+	// last valid query are kept meanwhile
+	queryErr error
+	// searchIndex narrows down the candidates of a query
+	searchIndex *SearchIndex
+	// Searches run as tea.Cmds. Each one gets the next generation number and
+	// only the results of the latest generation are applied.
+	generation     uint64
+	searching      bool
+	resetSelection bool
+	stop           chan struct{}
+	started        time.Time
+	debounce       time.Duration
 }
 
 // NewSearchService creates a new search service
 func NewSearchService(logger *LoggerService) *SearchService {
 	return &SearchService{
-		logger:  logger,
-		query:   "",
-		results: []FishCommand{},
-		index:   0,
+		logger:      logger,
+		query:       "",
+		matchMode:   MatchSubstring,
+		index:       0,
+		searchIndex: NewSearchIndex(),
+	}
+}
+
+// SetDebounce makes searches wait for a pause in typing of delay before
+// they start, so a burst of keystrokes only runs the last search
+func (s *SearchService) SetDebounce(delay time.Duration) {
+	s.debounce = delay
+}
+
+// SetRankMode tells the service how the commands it searches are ordered.
+// Fuzzy matches only get a recency bonus when the order is by recency.
+func (s *SearchService) SetRankMode(mode RankMode) {
+	s.rankMode = mode
+}
+
+// CycleMatchMode switches to the next match mode and re-runs the query
+func (s *SearchService) CycleMatchMode(commands []FishCommand) tea.Cmd {
+	s.matchMode = (s.matchMode + 1) % (MatchRegex + 1)
+	s.logger.Infof("Match mode set to %s", s.matchMode)
+	return s.UpdateQuery(commands, s.query)
+}
+
+// GetMatchMode returns the match mode used for the current query, which is
+// regex for queries starting with re: whatever the selected mode
+func (s *SearchService) GetMatchMode() MatchMode {
+	if strings.HasPrefix(s.query, regexPrefix) {
+		return MatchRegex
 	}
+	return s.matchMode
 }
 
-// UpdateQuery updates the search query and results
-func (s *SearchService) UpdateQuery(commands []FishCommand, query string) {
+// GetQueryError returns why the current query is invalid, or nil
+func (s *SearchService) GetQueryError() error {
+	return s.queryErr
+}
+
+// UpdateQuery sets the search query and starts searching for it. The
+// returned command delivers a searchResultsMsg for ApplyResults.
+func (s *SearchService) UpdateQuery(commands []FishCommand, query string) tea.Cmd {
 	s.query = query
-	s.results = s.searchCommands(commands, query)
-	s.index = 0
-	s.logger.Debugf("Search query updated to '%s', found %d results", query, len(s.results))
+	s.resetSelection = true
+	return s.start(commands)
+}
+
+// Refresh re-runs the current query against an updated history. Unless a
+// new query is still being searched, the same command stays selected when it
+// is among the new results.
+func (s *SearchService) Refresh(commands []FishCommand) tea.Cmd {
+	if !s.searching {
+		s.resetSelection = false
+	}
+	return s.start(commands)
+}
+
+// ApplyResults stores the outcome of a finished search. It returns false
+// for the results of a search that newer input has superseded.
+func (s *SearchService) ApplyResults(msg searchResultsMsg) bool {
+	if msg.generation != s.generation || !s.searching {
+		s.logger.Debugf("Dropping stale search results of generation %d", msg.generation)
+		return false
+	}
+	s.searching = false
+	s.stop = nil
+
+	s.queryErr = msg.err
+	if msg.err != nil {
+		s.logger.Debugf("Search query '%s' is invalid: %v", s.query, msg.err)
+		return true
+	}
+
+	selected := s.GetSelectedCommand()
+	var target FishCommand
+	if selected != nil {
+		target = *selected
+	}
+	s.outcome = msg.outcome
+	if s.resetSelection {
+		s.index = 0
+	} else if selected != nil {
+		if i := findCommand(s.outcome.commands(), target); i >= 0 {
+			s.index = i
+		}
+	}
+	if s.index >= len(s.outcome.hits) {
+		s.index = max(len(s.outcome.hits)-1, 0)
+	}
+	s.logger.Debugf("Search for '%s' found %d results", s.query, len(s.outcome.hits))
+	return true
+}
+
+// IsSearching reports whether a search has been running for longer than
+// searchPendingDelay
+func (s *SearchService) IsSearching() bool {
+	return s.searching && time.Since(s.started) >= searchPendingDelay
+}
+
+// start supersedes any running search with one for the current query and
+// returns the command that runs it
+func (s *SearchService) start(commands []FishCommand) tea.Cmd {
+	s.cancel()
+	s.generation++
+	s.searching = true
+	s.started = time.Now()
+	s.stop = make(chan struct{})
+
+	generation := s.generation
+	delay := s.debounce
+	request := searchRequest{
+		index:        s.searchIndex,
+		commands:     commands,
+		query:        s.query,
+		mode:         s.matchMode,
+		previous:     s.outcome,
+		recencyBonus: s.rankMode == RankRecency,
+		stop:         s.stop,
+	}
+	search := func() tea.Msg {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-request.stop:
+				return nil
+			}
+		}
+		outcome, err := request.run()
+		if errors.Is(err, errSearchStopped) {
+			return nil
+		}
+		return searchResultsMsg{generation: generation, outcome: outcome, err: err}
+	}
+	pending := tea.Tick(searchPendingDelay, func(time.Time) tea.Msg {
+		return searchPendingMsg{}
+	})
+	return tea.Batch(search, pending)
+}
+
+// cancel stops the running search, if any
+func (s *SearchService) cancel() {
+	if s.stop != nil {
+		close(s.stop)
+		s.stop = nil
+	}
 }
 
 // NavigateUp moves the selection up in the results
 func (s *SearchService) NavigateUp() {
-	if s.index > 0 {
-		s.index--
-	}
-	s.logger.Debugf("NavigateUp: index=%d, results=%d", s.index, len(s.results))
+	s.MoveSelection(-1)
 }
 
 // NavigateDown moves the selection down in the results
 func (s *SearchService) NavigateDown() {
-	// Limit navigation to top 5 results for display
-	maxIndex := len(s.results) - 1
-	if maxIndex > 4 {
-		maxIndex = 4 // Limit to top 5 (0-4)
-	}
-	if s.index < maxIndex {
-		s.index++
-	}
-	s.logger.Debugf("NavigateDown: index=%d, maxIndex=%d, results=%d", s.index, maxIndex, len(s.results))
+	s.MoveSelection(1)
+}
+
+// MoveSelection moves the selection by delta results, stopping at the first
+// and last result
+func (s *SearchService) MoveSelection(delta int) {
+	s.index = max(min(s.index+delta, len(s.outcome.hits)-1), 0)
+	s.logger.Debugf("MoveSelection: index=%d, results=%d", s.index, len(s.outcome.hits))
+}
+
+// SelectFirst selects the first result
+func (s *SearchService) SelectFirst() {
+	s.index = 0
+}
+
+// SelectLast selects the last result
+func (s *SearchService) SelectLast() {
+	s.index = max(len(s.outcome.hits)-1, 0)
 }
 
 // GetSelectedCommand returns the currently selected command
 func (s *SearchService) GetSelectedCommand() *FishCommand {
-	if len(s.results) == 0 || s.index < 0 || s.index >= len(s.results) {
+	if len(s.outcome.hits) == 0 || s.index < 0 || s.index >= len(s.outcome.hits) {
 		return nil
 	}
-	return &s.results[s.index]
+	return &s.outcome.history[s.outcome.hits[s.index].row]
 }
 
 // HasResults returns true if there are search results
 func (s *SearchService) HasResults() bool {
-	return len(s.results) > 0
+	return len(s.outcome.hits) > 0
 }
 
 // GetResultCount returns the number of search results
 func (s *SearchService) GetResultCount() int {
-	return len(s.results)
+	return len(s.outcome.hits)
+}
+
+// GetMatchRows returns the positions of the results in the history that
+// was searched, in ascending order
+func (s *SearchService) GetMatchRows() []int {
+	rows := make([]int, len(s.outcome.hits))
+	for i, hit := range s.outcome.hits {
+		rows[i] = int(hit.row)
+	}
+	slices.Sort(rows)
+	return rows
 }
 
 // GetQuery returns the current search query
@@ -75,9 +344,9 @@ func (s *SearchService) GetQuery() string {
 	return s.query
 }
 
-// GetResults returns the current search results
-func (s *SearchService) GetResults() []FishCommand {
-	return s.results
+// GetResult returns the i-th search result
+func (s *SearchService) GetResult(i int) SearchResult {
+	return s.outcome.result(i)
 }
 
 // GetIndex returns the current selection index
@@ -85,31 +354,406 @@ func (s *SearchService) GetIndex() int {
 	return s.index
 }
 
-// Clear resets the search state
+// Clear resets the search state and abandons any running search
 func (s *SearchService) Clear() {
+	s.cancel()
+	s.generation++
+	s.searching = false
 	s.query = ""
-	s.results = []FishCommand{}
+	s.queryErr = nil
+	s.outcome = searchOutcome{}
 	s.index = 0
 }
 
-// searchCommands is the internal search implementation
-func (s *SearchService) searchCommands(commands []FishCommand, query string) []FishCommand {
-	if query == "" {
-		// Return last 5 commands when no search query
-		if len(commands) <= 5 {
-			return commands
+// run performs the search. It returns errSearchStopped once the request
+// has been superseded.
+func (r searchRequest) run() (searchOutcome, error) {
+	outcome := searchOutcome{history: r.commands, query: r.query, mode: r.mode}
+
+	if r.mode == MatchRegex || strings.HasPrefix(r.query, regexPrefix) {
+		outcome.mode = MatchRegex
+		re, err := compileSearchRegex(strings.TrimPrefix(r.query, regexPrefix))
+		if err != nil {
+			return outcome, err
+		}
+		if re == nil {
+			outcome.hits = allHits(r.commands)
+			return outcome, nil
+		}
+		outcome.regex = re
+		for row, cmd := range r.commands {
+			if row%searchStopInterval == 0 && r.stopped() {
+				return outcome, errSearchStopped
+			}
+			if re.MatchString(cmd.Command) {
+				outcome.hits = append(outcome.hits, searchHit{row: int32(row)})
+			}
+		}
+		return outcome, nil
+	}
+
+	parsed, err := ParseQuery(r.query)
+	if err != nil {
+		return outcome, err
+	}
+	outcome.parsed = parsed
+	if parsed.IsEmpty() {
+		outcome.hits = allHits(r.commands)
+		return outcome, nil
+	}
+
+	now := time.Now()
+	fuzzy := r.mode == MatchFuzzy
+	within := r.refinableRows(outcome)
+	if within != nil {
+		outcome.hits = make([]searchHit, 0, len(within))
+	}
+	// A plain query depends on the command text alone, so every document is
+	// matched once however many entries share it
+	plain := isPlainQuery(parsed)
+	checked, stopped := 0, false
+	r.index.Each(r.commands, parsed, fuzzy, within, func(row int32, text docText) bool {
+		if checked++; checked%searchStopInterval == 0 && r.stopped() {
+			stopped = true
+			return false
+		}
+		cmd := &r.commands[row]
+		var score int
+		var ok bool
+		switch {
+		case !plain:
+			score, _, ok = outcome.match(cmd, text, false)
+		case text.match.checked:
+			score, ok = int(text.match.score), text.match.ok
+		default:
+			score, _, ok = outcome.match(cmd, text, false)
+			*text.match = docMatch{checked: true, ok: ok, score: int32(score)}
+		}
+		if !ok {
+			return true
+		}
+		if fuzzy && r.recencyBonus {
+			score += recencyBonus(cmd.When, now)
+		}
+		outcome.hits = append(outcome.hits, searchHit{row: row, score: int32(score)})
+		return true
+	})
+	if stopped {
+		return outcome, errSearchStopped
+	}
+
+	if fuzzy {
+		outcome.hits = sortByScore(outcome.hits)
+	}
+
+	return outcome, nil
+}
+
+// sortByScore orders hits best first, the order of the commands breaking
+// ties. Scores span a narrow range, so that is a counting sort unless they
+// are spread wider than there are hits.
+func sortByScore(hits []searchHit) []searchHit {
+	if len(hits) < 2 {
+		return hits
+	}
+	low, high := hits[0].score, hits[0].score
+	for _, hit := range hits {
+		low, high = min(low, hit.score), max(high, hit.score)
+	}
+	if int(high-low) > len(hits) {
+		slices.SortStableFunc(hits, func(a, b searchHit) int {
+			return int(b.score - a.score)
+		})
+		return hits
+	}
+
+	// next[high-score] is where the next hit with that score goes
+	next := make([]int, high-low+2)
+	for _, hit := range hits {
+		next[high-hit.score+1]++
+	}
+	for i := 1; i < len(next); i++ {
+		next[i] += next[i-1]
+	}
+	sorted := make([]searchHit, len(hits))
+	for _, hit := range hits {
+		sorted[next[high-hit.score]] = hit
+		next[high-hit.score]++
+	}
+	return sorted
+}
+
+// stopped reports whether the request has been superseded
+func (r searchRequest) stopped() bool {
+	select {
+	case <-r.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// refinableRows returns the history positions of the previous results when
+// the next outcome can only hold a subset of them, or nil when the whole
+// history has to be searched. That holds while the history and mode are
+// unchanged, the new query extends the previous one, and both are made of
+// positive text terms only, which is the common case of typing a query one
+// key at a time.
+func (r searchRequest) refinableRows(next searchOutcome) []int32 {
+	previous := r.previous
+	if previous.query == "" || !strings.HasPrefix(next.query, previous.query) ||
+		previous.mode != next.mode || !sameHistory(previous.history, next.history) {
+		return nil
+	}
+	if !isPlainQuery(previous.parsed) || !isPlainQuery(next.parsed) {
+		return nil
+	}
+
+	// Fuzzy hits are ordered by score, so a bitmap of the rows puts them
+	// back in history order
+	seen := make([]uint64, (len(previous.history)+63)/64)
+	for _, hit := range previous.hits {
+		seen[hit.row/64] |= 1 << (hit.row % 64)
+	}
+	rows := make([]int32, 0, len(previous.hits))
+	for i, word := range seen {
+		for ; word != 0; word &= word - 1 {
+			rows = append(rows, int32(i*64+bits.TrailingZeros64(word)))
+		}
+	}
+	return rows
+}
+
+// isPlainQuery reports whether a query only has positive text terms that
+// every match must contain
+func isPlainQuery(query Query) bool {
+	if query.IsEmpty() {
+		return false
+	}
+	for _, clause := range query.Clauses {
+		if len(clause) != 1 || clause[0].Negated {
+			return false
+		}
+		if clause[0].Kind != TermText && clause[0].Kind != TermPhrase {
+			return false
+		}
+	}
+	return true
+}
+
+// result builds the SearchResult of the i-th match, working out the
+// positions to highlight
+func (o *searchOutcome) result(i int) SearchResult {
+	hit := o.hits[i]
+	cmd := o.history[hit.row]
+	result := SearchResult{FishCommand: cmd, Score: int(hit.score)}
+	switch {
+	case o.regex != nil:
+		result.Matches = spanPositions(cmd.Command, o.regex.FindAllStringIndex(cmd.Command, -1))
+	case !o.parsed.IsEmpty():
+		_, result.Matches, _ = o.match(&cmd, newDocText(cmd.Command), true)
+	}
+	return result
+}
+
+// commands yields the matched commands with their index among the matches
+func (o *searchOutcome) commands() iter.Seq2[int, FishCommand] {
+	return func(yield func(int, FishCommand) bool) {
+		for i, hit := range o.hits {
+			if !yield(i, o.history[hit.row]) {
+				return
+			}
+		}
+	}
+}
+
+// match matches a command against every clause of the parsed query. A clause
+// scores as its best matching term. With highlight set the positions matched
+// by every term are collected as well. text is the text of the command.
+func (o *searchOutcome) match(cmd *FishCommand, text docText, highlight bool) (int, []int, bool) {
+	total := 0
+	var matches []int
+
+	for _, clause := range o.parsed.Clauses {
+		matched := false
+		best := 0
+		for _, term := range clause {
+			ok, score, positions := o.matchTerm(cmd, text, term, highlight)
+			if term.Negated {
+				ok, score, positions = !ok, 0, nil
+			}
+			if !ok {
+				continue
+			}
+			if !matched || score > best {
+				best = score
+			}
+			matched = true
+			matches = append(matches, positions...)
+		}
+		if !matched {
+			return 0, nil, false
+		}
+		total += best
+	}
+
+	return total, sortUniqueInts(matches), true
+}
+
+// matchTerm matches a single term, returning its score and, with highlight
+// set, the positions it matched in the command
+func (o *searchOutcome) matchTerm(cmd *FishCommand, text docText, term QueryTerm, highlight bool) (bool, int, []int) {
+	lower := text.lower
+	switch term.Kind {
+	case TermText, TermPhrase:
+		if term.Kind == TermText && o.mode == MatchFuzzy {
+			if highlight {
+				score, positions, ok := text.fuzzy.matchTerm([]rune(term.Value), nil)
+				return ok, score, positions
+			}
+			score, ok := text.fuzzy.score(term.Value)
+			return ok, score, nil
+		}
+		if !strings.Contains(lower, term.Value) {
+			return false, 0, nil
+		}
+		score := 0
+		if term.Kind == TermPhrase {
+			score = utf8.RuneCountInString(term.Value) * scoreMatch
+		}
+		if highlight {
+			return true, score, substringPositions(lower, term.Value)
+		}
+		return true, score, nil
+	case TermCmd:
+		positions, ok := firstTokenMatch(cmd.Command, term.Value)
+		return ok, len(positions) * scoreMatch, positions
+	case TermPath:
+		return containsFold(cmd.Paths, term.Value), 0, nil
+	case TermOrigin:
+		return containsFold(cmd.Origins, term.Value), 0, nil
+	case TermAfter:
+		for _, when := range cmd.RunTimes() {
+			if !when.Before(term.Time) {
+				return true, 0, nil
+			}
+		}
+	case TermBefore:
+		for _, when := range cmd.RunTimes() {
+			if !when.IsZero() && when.Before(term.Time) {
+				return true, 0, nil
+			}
 		}
-		return commands[:5]
 	}
+	return false, 0, nil
+}
 
-	query = strings.ToLower(query)
-	var results []FishCommand
+// compileSearchRegex compiles a regex query, or returns nil for an empty
+// one. The pattern is case insensitive unless it contains an upper case
+// letter.
+func compileSearchRegex(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return nil, nil
+	}
 
-	for _, cmd := range commands {
-		if strings.Contains(strings.ToLower(cmd.Command), query) {
-			results = append(results, cmd)
+	flags := ""
+	if strings.ToLower(pattern) == pattern {
+		flags = "(?i)"
+	}
+	re, err := regexp.Compile(flags + pattern)
+	if err != nil {
+		// Report the pattern as typed, without the flags
+		var syntaxErr *syntax.Error
+		if errors.As(err, &syntaxErr) {
+			syntaxErr.Expr = strings.TrimPrefix(syntaxErr.Expr, flags)
 		}
+		return nil, err
 	}
+	return re, nil
+}
 
-	return results
+// spanPositions converts byte spans of s into the rune positions they cover
+func spanPositions(s string, spans [][]int) []int {
+	var positions []int
+	for _, span := range spans {
+		start := utf8.RuneCountInString(s[:span[0]])
+		for n := range utf8.RuneCountInString(s[span[0]:span[1]]) {
+			positions = append(positions, start+n)
+		}
+	}
+	return positions
+}
+
+// allHits lists every command, shown when there is no query
+func allHits(commands []FishCommand) []searchHit {
+	hits := make([]searchHit, len(commands))
+	for i := range hits {
+		hits[i] = searchHit{row: int32(i)}
+	}
+	return hits
+}
+
+// substringPositions returns the rune positions of the first occurrence of
+// text in a lower case command
+func substringPositions(lower, text string) []int {
+	i := strings.Index(lower, text)
+	if i < 0 || text == "" {
+		return nil
+	}
+
+	start := utf8.RuneCountInString(lower[:i])
+	matches := make([]int, utf8.RuneCountInString(text))
+	for n := range matches {
+		matches[n] = start + n
+	}
+	return matches
+}
+
+// findCommand returns the index of target in commands, preferring the same
+// run and falling back to the same command text, or -1 if it is missing
+func findCommand(commands iter.Seq2[int, FishCommand], target FishCommand) int {
+	fallback := -1
+	for i, cmd := range commands {
+		if cmd.Command != target.Command {
+			continue
+		}
+		if cmd.When.Equal(target.When) {
+			return i
+		}
+		if fallback < 0 {
+			fallback = i
+		}
+	}
+	return fallback
+}
+
+// containsFold reports whether term, already lower case, appears in one of
+// the values, ignoring case
+func containsFold(values []string, term string) bool {
+	for _, value := range values {
+		if strings.Contains(strings.ToLower(value), term) {
+			return true
+		}
+	}
+	return false
+}
+
+// firstTokenMatch reports whether the first token of command equals token,
+// ignoring case, and returns the rune positions of that token
+func firstTokenMatch(command, token string) ([]int, bool) {
+	trimmed := strings.TrimLeftFunc(command, unicode.IsSpace)
+	first := trimmed
+	if end := strings.IndexFunc(trimmed, unicode.IsSpace); end >= 0 {
+		first = trimmed[:end]
+	}
+	if strings.ToLower(first) != token {
+		return nil, false
+	}
+
+	start := utf8.RuneCountInString(command[:len(command)-len(trimmed)])
+	positions := make([]int, utf8.RuneCountInString(first))
+	for n := range positions {
+		positions[n] = start + n
+	}
+	return positions, true
 }
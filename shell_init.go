@@ -0,0 +1,35 @@
+package main
+
+import "fmt"
+
+// fishInit binds Ctrl+R to pick a command from the history with bublsrc and
+// put it on the command line. fish does not export $fish_history, so the
+// widget does to let bublsrc open the history of the current session.
+// Loaded with: bublsrc init fish | source
+const fishInit = `function bublsrc-history-widget -d "Pick a command from the history with bublsrc"
+    set -lx fish_history $fish_history
+    set -l command (bublsrc pick | string collect)
+    and commandline -r -- $command
+    commandline -f repaint
+end
+
+bind \cr bublsrc-history-widget
+if bind -M insert >/dev/null 2>&1
+    bind -M insert \cr bublsrc-history-widget
+end
+`
+
+// shellInits holds the init script of each shell, for the init command
+var shellInits = map[string]string{
+	ShellFish: fishInit,
+}
+
+// printInit prints the script that binds bublsrc to a key in shell
+func printInit(shell string) error {
+	script, ok := shellInits[shell]
+	if !ok {
+		return fmt.Errorf("no init script for shell %q, use: bublsrc init fish", shell)
+	}
+	fmt.Print(script)
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TokenKind tells what part of a shell command a character belongs to
+type TokenKind int
+
+const (
+	// TokenText is a plain argument
+	TokenText TokenKind = iota
+	// TokenCommand is the name of a command or a keyword in its place
+	TokenCommand
+	// TokenFlag is an argument starting with a dash
+	TokenFlag
+	// TokenString is a quoted string
+	TokenString
+	// TokenVariable is a variable expansion or an assignment
+	TokenVariable
+	// TokenOperator is a pipe, a redirection, a separator or a parenthesis
+	TokenOperator
+	// TokenComment runs from # to the end of the line
+	TokenComment
+)
+
+// commandPrefixes are the words after which the next word is a command too
+var commandPrefixes = map[string]bool{
+	"and": true, "or": true, "not": true, "if": true, "while": true,
+	"else": true, "begin": true, "then": true, "do": true, "!": true,
+	"exec": true, "command": true, "builtin": true, "sudo": true,
+	"time": true, "nohup": true, "doas": true,
+}
+
+// shellOperatorChars are the characters that end a word and start an operator
+const shellOperatorChars = "|&;<>()"
+
+// tokenizeShell classifies every rune of a fish or POSIX shell command. It is
+// a highlighter rather than a parser: it never fails and tolerates unclosed
+// quotes and unbalanced parentheses. The result has one kind per rune.
+func tokenizeShell(command string) []TokenKind {
+	runes := []rune(command)
+	kinds := make([]TokenKind, len(runes))
+	commandStart := true
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '\n':
+			commandStart = true
+			i++
+		case unicode.IsSpace(r):
+			i++
+		case r == '#':
+			for ; i < len(runes) && runes[i] != '\n'; i++ {
+				kinds[i] = TokenComment
+			}
+		case strings.ContainsRune(shellOperatorChars, r) || isFdRedirect(runes, i):
+			end := scanOperator(runes, i)
+			op := string(runes[i:end])
+			for ; i < end; i++ {
+				kinds[i] = TokenOperator
+			}
+			// A redirection is followed by its target, anything else by a
+			// new command
+			commandStart = !strings.ContainsAny(op, "<>") && op != ")"
+		default:
+			end, word := scanWord(runes, kinds, i, commandStart)
+			i = end
+			switch {
+			case commandStart && (isAssignment(word) || strings.HasPrefix(word, "-")):
+				// FOO=bar cmd and sudo -E cmd: the command is still to come
+			case commandStart:
+				commandStart = commandPrefixes[word]
+			}
+		}
+	}
+	return kinds
+}
+
+// scanWord classifies the word starting at i and returns where it ends and
+// its text without quotes
+func scanWord(runes []rune, kinds []TokenKind, i int, commandStart bool) (int, string) {
+	base := TokenText
+	switch {
+	case runes[i] == '-':
+		base = TokenFlag
+	case commandStart && isAssignmentStart(runes, i):
+		base = TokenVariable
+	case commandStart:
+		base = TokenCommand
+	}
+
+	var word strings.Builder
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r) || strings.ContainsRune(shellOperatorChars, r):
+			return i, word.String()
+		case r == '\'' || r == '"':
+			i = scanString(runes, kinds, i, &word)
+		case r == '$':
+			i = scanVariable(runes, kinds, i)
+		case r == '\\' && i+1 < len(runes):
+			kinds[i], kinds[i+1] = base, base
+			word.WriteRune(runes[i+1])
+			i += 2
+		default:
+			kinds[i] = base
+			word.WriteRune(r)
+			if base == TokenVariable && r == '=' {
+				// The value of an assignment is plain text
+				base = TokenText
+			}
+			i++
+		}
+	}
+	return i, word.String()
+}
+
+// scanString classifies a quoted string starting at i, with the variables
+// expanded inside double quotes, and returns where it ends
+func scanString(runes []rune, kinds []TokenKind, i int, word *strings.Builder) int {
+	quote := runes[i]
+	kinds[i] = TokenString
+	i++
+	for i < len(runes) {
+		r := runes[i]
+		switch {
+		case r == quote:
+			kinds[i] = TokenString
+			return i + 1
+		case r == '\\' && i+1 < len(runes):
+			kinds[i], kinds[i+1] = TokenString, TokenString
+			word.WriteRune(runes[i+1])
+			i += 2
+		case r == '$' && quote == '"':
+			i = scanVariable(runes, kinds, i)
+		default:
+			kinds[i] = TokenString
+			word.WriteRune(r)
+			i++
+		}
+	}
+	return i
+}
+
+// scanVariable classifies a $name, ${name} or $(...) starting at i and
+// returns where it ends. A bare $ is left as it is.
+func scanVariable(runes []rune, kinds []TokenKind, i int) int {
+	start := i
+	i++
+	switch {
+	case i < len(runes) && runes[i] == '{':
+		for i < len(runes) && runes[i] != '}' {
+			i++
+		}
+		i = min(i+1, len(runes))
+	case i < len(runes) && runes[i] == '(':
+		// $(...) substitutes a command, which the caller tokenizes as an
+		// operator followed by a new command
+		kinds[start] = TokenOperator
+		return i
+	default:
+		for i < len(runes) && isNameRune(runes[i]) {
+			i++
+		}
+		// Special parameters such as $? and $1
+		if i == start+1 && i < len(runes) && strings.ContainsRune("?#@*!$-0123456789", runes[i]) {
+			i++
+		}
+	}
+	if i == start+1 {
+		kinds[start] = TokenText
+		return i
+	}
+	for j := start; j < i; j++ {
+		kinds[j] = TokenVariable
+	}
+	return i
+}
+
+// scanOperator returns where the operator starting at i ends, taking in
+// &&, ||, |&, >>, &>, 2>&1 and the like
+func scanOperator(runes []rune, i int) int {
+	for i < len(runes) && unicode.IsDigit(runes[i]) {
+		i++
+	}
+	if i >= len(runes) {
+		return i
+	}
+	r := runes[i]
+	i++
+	if r == '(' || r == ')' || r == ';' {
+		return i
+	}
+	for i < len(runes) && strings.ContainsRune("|&<>", runes[i]) {
+		i++
+	}
+	// A file descriptor after a redirection, as in 2>&1 or >&-
+	for i < len(runes) && (unicode.IsDigit(runes[i]) || runes[i] == '-') && strings.ContainsRune("<>", r) {
+		i++
+	}
+	return i
+}
+
+// isFdRedirect reports whether a redirection with a file descriptor such as
+// 2> starts at i
+func isFdRedirect(runes []rune, i int) bool {
+	if i > 0 && !unicode.IsSpace(runes[i-1]) {
+		return false
+	}
+	j := i
+	for j < len(runes) && unicode.IsDigit(runes[j]) {
+		j++
+	}
+	return j > i && j < len(runes) && (runes[j] == '>' || runes[j] == '<')
+}
+
+// isAssignmentStart reports whether a NAME= assignment starts at i
+func isAssignmentStart(runes []rune, i int) bool {
+	j := i
+	for j < len(runes) && isNameRune(runes[j]) {
+		j++
+	}
+	return j > i && !unicode.IsDigit(runes[i]) && j < len(runes) && runes[j] == '='
+}
+
+// isAssignment reports whether a word is a NAME=value assignment
+func isAssignment(word string) bool {
+	return isAssignmentStart([]rune(word), 0)
+}
+
+// isNameRune reports whether r can be part of a variable name
+func isNameRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,98 @@
+package main
+
+import "testing"
+
+// kindLetters renders one letter per rune: . text, c command, f flag,
+// s string, v variable, o operator, # comment
+func kindLetters(kinds []TokenKind) string {
+	letters := make([]byte, len(kinds))
+	for i, kind := range kinds {
+		letters[i] = ".cfsvo#"[kind]
+	}
+	return string(letters)
+}
+
+func TestTokenizeShell(t *testing.T) {
+	tests := []struct {
+		command string
+		want    string
+	}{
+		{"git commit -m 'fix it'", "ccc........ff.ssssssss"},
+		{"FOO=bar make -j4", "vvvv....cccc.fff"},
+		{"sudo -E apt install vim", "cccc.ff.ccc............"},
+		{"ls | grep x && echo done; pwd", "cc.o.cccc...oo.cccc.....o.ccc"},
+		{"cat < in 2>&1 > out", "ccc.o....oooo.o...."},
+		{`echo "$HOME/x" $1 ${PATH} $`, "cccc.svvvvvsss.vv.vvvvvvv.."},
+		{"echo $(date) # when", "cccc.oocccco.######"},
+		{"if test -f a\n  cat a", "cc.cccc.ff.....ccc.."},
+		{"echo 'unclosed", "cccc.sssssssss"},
+		{`a\ b c`, "cccc.."},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.command, func(t *testing.T) {
+			if got := kindLetters(tokenizeShell(tt.command)); got != tt.want {
+				t.Errorf("tokenizeShell(%q)\n got %q\nwant %q", tt.command, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestScanWord(t *testing.T) {
+	tests := []struct {
+		text         string
+		commandStart bool
+		wantEnd      int
+		wantWord     string
+		wantKinds    string
+	}{
+		{"git status", true, 3, "git", "ccc......."},
+		{"--all x", false, 5, "--all", "fffff.."},
+		{`'a b'c|d`, false, 6, "a bc", "sssss..."},
+		{`x\ y z`, false, 4, "x y", "......"},
+		{`"$v"x;`, false, 5, "x", "svvs.."},
+		{"A=1 cmd", true, 3, "A=1", "vv....."},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			runes := []rune(tt.text)
+			kinds := make([]TokenKind, len(runes))
+			end, word := scanWord(runes, kinds, 0, tt.commandStart)
+			if end != tt.wantEnd || word != tt.wantWord {
+				t.Errorf("scanWord(%q) = %d, %q, want %d, %q", tt.text, end, word, tt.wantEnd, tt.wantWord)
+			}
+			if got := kindLetters(kinds); got != tt.wantKinds {
+				t.Errorf("kinds = %q, want %q", got, tt.wantKinds)
+			}
+		})
+	}
+}
+
+func TestScanOperator(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"| b", 1},
+		{"|| b", 2},
+		{"&& b", 2},
+		{"|& b", 2},
+		{">> f", 2},
+		{"&> f", 2},
+		{"2>&1 x", 4},
+		{">&- x", 3},
+		{";; x", 1},
+		{"(x)", 1},
+		{"2", 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			if got := scanOperator([]rune(tt.text), 0); got != tt.want {
+				t.Errorf("scanOperator(%q) = %d, want %d", tt.text, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/muesli/termenv"
+)
+
+// Theme is the color palette of the interface. Custom themes are read from
+// the config file; the colors they leave out come from their base theme.
+type Theme struct {
+	// Base names the built-in theme a custom theme starts from: dark, the
+	// default, or light
+	Base string `json:"base"`
+
+	Primary   lipgloss.Color `json:"primary"`
+	Secondary lipgloss.Color `json:"secondary"`
+	Accent    lipgloss.Color `json:"accent"`
+	Text      lipgloss.Color `json:"text"`
+	Muted     lipgloss.Color `json:"muted"`
+	Error     lipgloss.Color `json:"error"`
+	Success   lipgloss.Color `json:"success"`
+	// Flag and Variable color those parts of highlighted commands
+	Flag     lipgloss.Color `json:"flag"`
+	Variable lipgloss.Color `json:"variable"`
+	// Backgrounds of the selected row, of status messages and of the
+	// characters matching the query
+	Selection        lipgloss.Color `json:"selection"`
+	StatusBackground lipgloss.Color `json:"status_background"`
+	MatchBackground  lipgloss.Color `json:"match_background"`
+}
+
+// The built-in themes. An empty color leaves the terminal's own color.
+var (
+	darkTheme = Theme{
+		Primary:          "#00D4AA", // Teal
+		Secondary:        "#7C3AED", // Purple
+		Accent:           "#F59E0B", // Amber
+		Text:             "#F8FAFC", // Light gray
+		Muted:            "#64748B", // Slate
+		Error:            "#EF4444", // Red
+		Success:          "#10B981", // Green
+		Flag:             "#38BDF8", // Sky
+		Variable:         "#F472B6", // Pink
+		Selection:        "#1E293B",
+		StatusBackground: "#0F172A",
+		MatchBackground:  "#422006",
+	}
+
+	lightTheme = Theme{
+		Primary:          "#0F766E", // Dark teal
+		Secondary:        "#6D28D9", // Purple
+		Accent:           "#B45309", // Dark amber
+		Text:             "#0F172A", // Near black
+		Muted:            "#64748B", // Slate
+		Error:            "#DC2626", // Red
+		Success:          "#047857", // Green
+		Flag:             "#0369A1", // Dark sky
+		Variable:         "#BE185D", // Dark pink
+		Selection:        "#E2E8F0",
+		StatusBackground: "#F1F5F9",
+		MatchBackground:  "#FEF3C7",
+	}
+
+	// noColorTheme keeps bold, italic and underline but no colors, for
+	// NO_COLOR
+	noColorTheme = Theme{}
+
+	builtinThemes = map[string]Theme{
+		"dark":  darkTheme,
+		"light": lightTheme,
+	}
+)
+
+// colors returns the colors of the theme, for filling them in one by one
+func (t *Theme) colors() []*lipgloss.Color {
+	return []*lipgloss.Color{
+		&t.Primary, &t.Secondary, &t.Accent, &t.Text, &t.Muted, &t.Error,
+		&t.Success, &t.Flag, &t.Variable, &t.Selection, &t.StatusBackground,
+		&t.MatchBackground,
+	}
+}
+
+// ResolveTheme returns the theme called name: dark, light, one of the custom
+// themes, or auto (the default) to pick dark or light from the terminal's
+// background. NO_COLOR wins over any of them.
+func ResolveTheme(name string, custom map[string]Theme) (Theme, error) {
+	if os.Getenv("NO_COLOR") != "" {
+		return noColorTheme, nil
+	}
+
+	if theme, ok := custom[name]; ok {
+		baseName := theme.Base
+		if baseName == "" {
+			baseName = "dark"
+		}
+		base, ok := builtinThemes[strings.ToLower(baseName)]
+		if !ok {
+			return darkTheme, fmt.Errorf("theme %q: unknown base theme %q, use dark or light", name, theme.Base)
+		}
+		baseColors := base.colors()
+		for i, color := range theme.colors() {
+			if *color == "" {
+				*color = *baseColors[i]
+			}
+		}
+		return theme, nil
+	}
+
+	switch name = strings.ToLower(name); name {
+	case "", "auto":
+		if lipgloss.HasDarkBackground() {
+			return darkTheme, nil
+		}
+		return lightTheme, nil
+	}
+	if theme, ok := builtinThemes[name]; ok {
+		return theme, nil
+	}
+
+	names := []string{"auto", "dark", "light"}
+	for customName := range custom {
+		names = append(names, customName)
+	}
+	sort.Strings(names[3:])
+	return darkTheme, fmt.Errorf("unknown theme %q, use %s", name, strings.Join(names, ", "))
+}
+
+// useNoColorProfile lets NO_COLOR terminals keep text attributes such as bold
+// and underline, which the color profile would otherwise drop with the
+// colors. The colors themselves are left out by noColorTheme. output is
+// where the interface is drawn.
+func useNoColorProfile(output io.Writer) {
+	if os.Getenv("NO_COLOR") == "" {
+		return
+	}
+	if termenv.NewOutput(output).ColorProfile() != termenv.Ascii {
+		lipgloss.SetColorProfile(termenv.ANSI)
+	}
+}
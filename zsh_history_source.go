@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// zshMeta is the byte zsh uses to escape special bytes in its history file
+const zshMeta = 0x83
+
+// ZshHistorySource reads zsh's history file.
+//
+// With EXTENDED_HISTORY every entry starts with `: <epoch>:<duration>;`.
+// Multi-line commands are written with a trailing backslash on every line
+// but the last. Lines without the extended header are plain commands that
+// carry no time.
+type ZshHistorySource struct {
+	path  string
+	label string
+}
+
+// Name returns the shell the source reads
+func (s *ZshHistorySource) Name() string {
+	return ShellZsh
+}
+
+// Path returns the history file the source reads
+func (s *ZshHistorySource) Path() string {
+	return s.path
+}
+
+// Label returns the origin recorded on the source's entries
+func (s *ZshHistorySource) Label() string {
+	return s.label
+}
+
+// Decode parses zsh history from r
+func (s *ZshHistorySource) Decode(r io.Reader, emit func(FishCommand)) ([]ParseDiagnostic, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), maxHistoryLineSize)
+
+	var diagnostics []ParseDiagnostic
+	var current *FishCommand
+	line := 0
+
+	for scanner.Scan() {
+		line++
+		text := unmetafyZsh(strings.TrimRight(scanner.Text(), "\r"))
+
+		if current == nil {
+			if strings.TrimSpace(text) == "" {
+				continue
+			}
+			cmd, err := parseZshHeader(text)
+			if err != nil {
+				diagnostics = append(diagnostics, ParseDiagnostic{Line: line, Reason: err.Error()})
+				continue
+			}
+			current = &cmd
+			text = current.Command
+			current.Command = ""
+		} else {
+			current.Command += "\n"
+		}
+
+		// A trailing backslash continues the command on the next line
+		if continued, ok := strings.CutSuffix(text, `\`); ok {
+			current.Command += continued
+			continue
+		}
+		current.Command += text
+		if current.Command != "" {
+			emit(*current)
+		} else {
+			diagnostics = append(diagnostics, ParseDiagnostic{Line: line, Reason: "empty command"})
+		}
+		current = nil
+	}
+
+	if current != nil && current.Command != "" {
+		emit(*current)
+	}
+	return diagnostics, scanner.Err()
+}
+
+// Finished returns the lines of data up to the end of the last command that
+// is not continued with a trailing backslash
+func (s *ZshHistorySource) Finished(data []byte) int {
+	finished := 0
+	for start := 0; ; {
+		n := bytes.IndexByte(data[start:], '\n')
+		if n < 0 {
+			return finished
+		}
+		line := bytes.TrimRight(data[start:start+n], "\r")
+		start += n + 1
+		if !bytes.HasSuffix(line, []byte(`\`)) {
+			finished = start
+		}
+	}
+}
+
+// parseZshHeader splits an extended history line into its timestamp and
+// command. Lines without the header are returned as they are.
+func parseZshHeader(line string) (FishCommand, error) {
+	rest, ok := strings.CutPrefix(line, ": ")
+	if !ok {
+		return FishCommand{Command: line}, nil
+	}
+
+	header, command, found := strings.Cut(rest, ";")
+	if !found {
+		return FishCommand{}, fmt.Errorf("extended history header without ';'")
+	}
+	epoch, _, _ := strings.Cut(header, ":")
+	timestamp, err := strconv.ParseInt(strings.TrimSpace(epoch), 10, 64)
+	if err != nil {
+		return FishCommand{}, fmt.Errorf("invalid timestamp %q", epoch)
+	}
+	return FishCommand{Command: command, When: time.Unix(timestamp, 0)}, nil
+}
+
+// unmetafyZsh undoes zsh's metafication, where special bytes are stored as
+// the Meta byte followed by the original byte xor 32
+func unmetafyZsh(line string) string {
+	if strings.IndexByte(line, zshMeta) < 0 {
+		return line
+	}
+
+	b := make([]byte, 0, len(line))
+	for i := 0; i < len(line); i++ {
+		if line[i] == zshMeta && i+1 < len(line) {
+			i++
+			b = append(b, line[i]^32)
+			continue
+		}
+		b = append(b, line[i])
+	}
+	return string(b)
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestZshHistoryDecode(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		want      []historyEntry
+		wantLines []int
+	}{
+		{
+			name:  "plain lines",
+			input: "ls\n\ncd /tmp\r\n",
+			want:  []historyEntry{{"ls", 0}, {"cd /tmp", 0}},
+		},
+		{
+			name:  "extended format",
+			input: ": 1700000000:0;ls -la\n: 1700000060:12;make test\n",
+			want:  []historyEntry{{"ls -la", 1700000000}, {"make test", 1700000060}},
+		},
+		{
+			name:  "semicolons in the command",
+			input: ": 1700000000:0;cd /tmp; ls\n",
+			want:  []historyEntry{{"cd /tmp; ls", 1700000000}},
+		},
+		{
+			name:  "continued lines",
+			input: ": 1700000000:0;for f in *\\\ndo echo $f\\\ndone\npwd\n",
+			want:  []historyEntry{{"for f in *\ndo echo $f\ndone", 1700000000}, {"pwd", 0}},
+		},
+		{
+			name:  "metafied bytes",
+			input: ": 1700000000:0;echo \xe2\x83\xa6\x83\xb2\n",
+			want:  []historyEntry{{"echo →", 1700000000}},
+		},
+		{
+			name:  "unfinished continuation at the end",
+			input: "echo a\\\nb\\",
+			want:  []historyEntry{{"echo a\nb", 0}},
+		},
+		{
+			name:      "broken headers",
+			input:     ": 1700000000:0 ls\n: soon:0;ls\n: 1700000000:0;\npwd\n",
+			want:      []historyEntry{{"pwd", 0}},
+			wantLines: []int{1, 2, 3},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			commands, diagnostics := decodeAll(t, &ZshHistorySource{}, tt.input)
+			if got := historyEntries(commands); !slices.Equal(got, tt.want) {
+				t.Errorf("commands = %q, want %q", got, tt.want)
+			}
+			if got := diagnosticLines(diagnostics); !slices.Equal(got, tt.wantLines) {
+				t.Errorf("diagnostics on lines %v, want %v", got, tt.wantLines)
+			}
+		})
+	}
+}
+
+func TestUnmetafyZsh(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"plain", "plain"},
+		{"\xe2\x83\xa6\x83\xb2", "→"},
+		{"\x83\xa0", "\x80"},
+		{"trailing \x83", "trailing \x83"},
+	}
+
+	for _, tt := range tests {
+		if got := unmetafyZsh(tt.line); got != tt.want {
+			t.Errorf("unmetafyZsh(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+}